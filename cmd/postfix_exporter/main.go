@@ -5,6 +5,10 @@ import (
 	"net/http"
 	_ "net/http/pprof"
 	"os"
+	"os/signal"
+	"regexp"
+	"strings"
+	"syscall"
 
 	"github.com/alecthomas/kingpin/v2"
 	"github.com/prometheus/client_golang/prometheus"
@@ -24,12 +28,30 @@ func main() {
 	var (
 		configFile    = kingpin.Flag("config.file", "Postfix Exporter configuration file.").String()
 		configCheck   = kingpin.Flag("config.check", "If true, validate the config file and then exit.").Default().Bool()
-		collectorType = kingpin.Flag("collector", "Collector type to scrape metrics with. One of: [file, journald]").Default("file").Enum("file", "journald")
-		instance      = kingpin.Flag("postfix.instance", "Postfix instance name.").Default("postfix").String()
+		collectorType = kingpin.Flag("collector", "Collector type to scrape metrics with. One of: [file, journald, syslog]").Default("file").Enum("file", "journald", "syslog")
+		instances     = kingpin.Flag("postfix.instance", "Postfix instance name. Can be specified multiple times.").Default("postfix").Strings()
+		instanceRe    = kingpin.Flag("postfix.instance-regexp", "Regular expression matching Postfix instance names. Overrides --postfix.instance.").Default("").String()
 		logPath       = kingpin.Flag("file.log", "Path to a file containing Postfix logs.").Default("/var/log/mail.log").String()
+		positionFile  = kingpin.Flag("file.position-file", "Path to a file to persist the read position of the log file in.").Default("").String()
 		journaldPath  = kingpin.Flag("journald.path", "Path where a systemd journal residing in.").Default("").String()
 		journaldUnit  = kingpin.Flag("journald.unit", "Postfix systemd service name.").Default("postfix@-.service").String()
 		journaldSince = kingpin.Flag("journald.since", "Time since which to read from a systemd journal.").Default("0s").Duration()
+		cursorFile    = kingpin.Flag("journald.cursor-file", "Path to a file to persist the systemd journal cursor in.").Default("").String()
+		syslogUDP     = kingpin.Flag("syslog.udp-address", "Address to listen on for syslog messages over UDP.").Default("").String()
+		syslogTCP     = kingpin.Flag("syslog.tcp-address", "Address to listen on for syslog messages over TCP.").Default("").String()
+		syslogUnix    = kingpin.Flag("syslog.unix-path", "Path to a unix datagram socket to listen on for syslog messages.").Default("").String()
+		queueDir      = kingpin.Flag("queue.directory", "Path to the Postfix queue directory to scan for queue sizes.").Default("").String()
+		queueInterval = kingpin.Flag("queue.scan-interval", "Interval between scans of the Postfix queue directory.").Default("1m").Duration()
+		postqueue     = kingpin.Flag("postqueue", "If true, export per-destination queue metrics from the postqueue -j output.").Default("false").Bool()
+		postqueueCmd  = kingpin.Flag("postqueue.command", "Command to run to get the postqueue -j output.").Default("postqueue -j").String()
+		postqueueFile = kingpin.Flag("postqueue.file", "Path to a file or FIFO to read the postqueue -j output from instead of running the command. A FIFO requires a positive interval.").Default("").String()
+		postqueueIntv = kingpin.Flag("postqueue.interval", "Interval between reads of the postqueue -j output. If zero, it's read on every scrape.").Default("0s").Duration()
+		postqueueTopN = kingpin.Flag("postqueue.top-domains", "Maximum number of recipient domains to export, the rest are reported as other. If zero, there's no limit.").Default("20").Int()
+		postconf      = kingpin.Flag("postconf", "If true, export selected Postfix configuration settings.").Default("false").Bool()
+		postconfCmd   = kingpin.Flag("postconf.command", "Command to run to get all Postfix configuration settings.").Default("postconf -x").String()
+		postconfDir   = kingpin.Flag("postconf.config-directory", "Path to the Postfix configuration directory to parse main.cf and master.cf in instead of running the command.").Default("").String()
+		postconfNames = kingpin.Flag("postconf.setting", "Postfix configuration setting to export. Can be specified multiple times.").Default("mail_version", "smtpd_tls_security_level", "inet_protocols", "message_size_limit").Strings()
+		postconfIntv  = kingpin.Flag("postconf.interval", "Interval between reads of the Postfix configuration. If zero, it's read on every scrape.").Default("1m").Duration()
 		test          = kingpin.Flag("test", "If true, read logs, print metrics and then exit.").Default("false").Bool()
 		toolkitFlags  = webflag.AddFlags(kingpin.CommandLine, ":9907")
 		metricsPath   = kingpin.Flag("web.telemetry-path", "Path under which to expose metrics.").Default("/metrics").String()
@@ -62,31 +84,123 @@ func main() {
 		logger.Info("Loaded config file")
 	}
 
+	var instance *regexp.Regexp
+	if *instanceRe != "" {
+		instance, err = regexp.Compile("^(?:" + *instanceRe + ")$")
+		if err != nil {
+			logger.Error("Error parsing instance regexp", "err", err)
+			os.Exit(1)
+		}
+	} else {
+		names := make([]string, len(*instances))
+		for i, name := range *instances {
+			names[i] = regexp.QuoteMeta(name)
+		}
+		instance = regexp.MustCompile("^(?:" + strings.Join(names, "|") + ")$")
+	}
+
 	prometheus.MustRegister(versioncollector.NewCollector("postfix_exporter"))
 	var collector exporter.Collector
 	switch *collectorType {
 	case "file":
 		collector = &exporter.File{
-			Path: *logPath,
-			Test: *test,
+			Path:         *logPath,
+			PositionFile: *positionFile,
+			Test:         *test,
 		}
 	case "journald":
 		collector = &exporter.Journald{
-			Path:  *journaldPath,
-			Unit:  *journaldUnit,
-			Since: *journaldSince,
-			Test:  *test,
+			Path:       *journaldPath,
+			Unit:       *journaldUnit,
+			Since:      *journaldSince,
+			CursorFile: *cursorFile,
+			Test:       *test,
+		}
+	case "syslog":
+		if *test {
+			logger.Error("Test mode is not supported by the syslog collector")
+			os.Exit(1)
+		}
+		collector = &exporter.Syslog{
+			UDPAddress: *syslogUDP,
+			TCPAddress: *syslogTCP,
+			UnixPath:   *syslogUnix,
 		}
 	}
-	exporter, err := exporter.New(collector, *instance, cfg, logger)
+	if *queueDir != "" {
+		queue, err := exporter.NewQueue(*queueDir, *queueInterval, logger)
+		if err != nil {
+			logger.Error("Error creating the queue scanner", "err", err)
+			os.Exit(1)
+		}
+		defer queue.Close()
+		prometheus.MustRegister(queue)
+	}
+	if *postqueue {
+		pq, err := exporter.NewPostqueue(strings.Fields(*postqueueCmd), *postqueueFile, *postqueueIntv, *postqueueTopN, logger)
+		if err != nil {
+			logger.Error("Error creating the postqueue collector", "err", err)
+			os.Exit(1)
+		}
+		defer pq.Close()
+		prometheus.MustRegister(pq)
+	}
+	if *postconf {
+		pc, err := exporter.NewPostconf(strings.Fields(*postconfCmd), *postconfDir, *postconfNames, *postconfIntv, logger)
+		if err != nil {
+			logger.Error("Error creating the postconf collector", "err", err)
+			os.Exit(1)
+		}
+		defer pc.Close()
+		prometheus.MustRegister(pc)
+	}
+
+	exporter, err := exporter.New(collector, instance, cfg, logger)
 	if err != nil {
 		logger.Error("Error creating the exporter", "err", err)
 		os.Exit(1)
 	}
 	defer exporter.Close()
-	prometheus.MustRegister(exporter)
+	if err := prometheus.Register(exporter); err != nil {
+		logger.Error("Error registering the exporter", "err", err)
+		os.Exit(1)
+	}
+	configSuccess := prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: "postfix_exporter",
+		Name:      "config_last_reload_successful",
+		Help:      "Whether the last configuration reload attempt was successful.",
+	})
+	configSuccessTime := prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: "postfix_exporter",
+		Name:      "config_last_reload_success_timestamp_seconds",
+		Help:      "Timestamp of the last successful configuration reload.",
+	})
+	prometheus.MustRegister(configSuccess, configSuccessTime)
+	configSuccess.Set(1)
+	configSuccessTime.SetToCurrentTime()
+	reload := func() error {
+		var (
+			cfg *config.Config
+			err error
+		)
+		if *configFile != "" {
+			cfg, err = config.Load(*configFile)
+		}
+		if err == nil {
+			err = exporter.Reload(cfg)
+		}
+		if err != nil {
+			configSuccess.Set(0)
+			logger.Error("Error reloading config", "err", err)
+			return err
+		}
+		configSuccess.Set(1)
+		configSuccessTime.SetToCurrentTime()
+		logger.Info("Reloaded config file")
+		return nil
+	}
 	if *test {
-		collector.Wait()
+		exporter.Wait()
 		mfs, err := prometheus.DefaultGatherer.Gather()
 		if err != nil {
 			logger.Error("Error collecting metrics", "err", err)
@@ -104,7 +218,33 @@ func main() {
 		return
 	}
 
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	reloadCh := make(chan chan error)
+	go func() {
+		for {
+			select {
+			case <-hup:
+				reload()
+			case errCh := <-reloadCh:
+				errCh <- reload()
+			}
+		}
+	}()
+
 	http.Handle(*metricsPath, promhttp.Handler())
+	http.HandleFunc("/-/reload", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, "This endpoint requires a POST request.", http.StatusMethodNotAllowed)
+			return
+		}
+		errCh := make(chan error)
+		reloadCh <- errCh
+		if err := <-errCh; err != nil {
+			http.Error(w, "Failed to reload config: "+err.Error(), http.StatusInternalServerError)
+		}
+	})
 	if *metricsPath != "/" {
 		landingConfig := web.LandingConfig{
 			Name:        "Postfix Exporter",
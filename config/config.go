@@ -5,6 +5,8 @@ import (
 	"os"
 	"regexp"
 	"strconv"
+	"strings"
+	"time"
 
 	"gopkg.in/yaml.v3"
 )
@@ -13,6 +15,14 @@ type Config struct {
 	StatusReplies        []StatusReplyMatchConfig `yaml:"status_replies,omitempty"`
 	SmtpReplies          []ReplyMatchConfig       `yaml:"smtp_replies,omitempty"`
 	NoqueueRejectReplies []ReplyMatchConfig       `yaml:"noqueue_reject_replies,omitempty"`
+	NoqueueRejectReasons []ReplyMatchConfig       `yaml:"noqueue_reject_reasons,omitempty"`
+	MilterReplies        []ReplyMatchConfig       `yaml:"milter_replies,omitempty"`
+	MessageTracking      MessageTrackingConfig    `yaml:"message_tracking,omitempty"`
+	DelayMetrics         DelayMetricsConfig       `yaml:"delay_metrics,omitempty"`
+	CustomMetrics        []CustomMetricConfig     `yaml:"custom_metrics,omitempty"`
+	SASLUsernames        *SASLUsernamesConfig     `yaml:"sasl_usernames,omitempty"`
+	ConnectionFailures   ConnectionFailuresConfig `yaml:"connection_failures,omitempty"`
+	DeliveryDomains      *DeliveryDomainsConfig   `yaml:"delivery_domains,omitempty"`
 }
 
 func Load(name string) (*Config, error) {
@@ -27,6 +37,13 @@ func Load(name string) (*Config, error) {
 	if err = d.Decode(&cfg); err != nil {
 		return nil, errors.New("error parsing config file: " + err.Error())
 	}
+	names := map[string]bool{}
+	for _, metric := range cfg.CustomMetrics {
+		if names[metric.Name] {
+			return nil, errors.New("error parsing config file: duplicate custom metric name " + strconv.Quote(metric.Name))
+		}
+		names[metric.Name] = true
+	}
 	return &cfg, nil
 }
 
@@ -66,6 +83,252 @@ func (cfg *ReplyMatchConfig) UnmarshalYAML(value *yaml.Node) error {
 	return nil
 }
 
+type MessageTrackingConfig struct {
+	MaxMessages int           `yaml:"max_messages,omitempty"`
+	TTL         time.Duration `yaml:"ttl,omitempty"`
+}
+
+func (cfg *MessageTrackingConfig) UnmarshalYAML(value *yaml.Node) error {
+	type plain MessageTrackingConfig
+	if err := value.Decode((*plain)(cfg)); err != nil {
+		return err
+	}
+	if cfg.MaxMessages < 0 {
+		return errors.New("negative max messages")
+	}
+	if cfg.TTL < 0 {
+		return errors.New("negative ttl")
+	}
+	return nil
+}
+
+type SASLUsernamesConfig struct {
+	Allowlist    []string `yaml:"allowlist,omitempty"`
+	Hash         bool     `yaml:"hash,omitempty"`
+	MaxUsernames int      `yaml:"max_usernames,omitempty"`
+}
+
+func (cfg *SASLUsernamesConfig) UnmarshalYAML(value *yaml.Node) error {
+	type plain SASLUsernamesConfig
+	if err := value.Decode((*plain)(cfg)); err != nil {
+		return err
+	}
+	if cfg.MaxUsernames < 0 {
+		return errors.New("negative max usernames")
+	}
+	return nil
+}
+
+type ConnectionFailuresConfig struct {
+	Domains []string `yaml:"domains,omitempty"`
+}
+
+func (cfg *ConnectionFailuresConfig) UnmarshalYAML(value *yaml.Node) error {
+	type plain ConnectionFailuresConfig
+	if err := value.Decode((*plain)(cfg)); err != nil {
+		return err
+	}
+	for i, domain := range cfg.Domains {
+		domain = strings.ToLower(strings.Trim(domain, "."))
+		if domain == "" {
+			return errors.New("empty domain")
+		}
+		cfg.Domains[i] = domain
+	}
+	return nil
+}
+
+type DeliveryDomainsConfig struct {
+	Relay      bool                `yaml:"relay,omitempty"`
+	Recipient  bool                `yaml:"recipient,omitempty"`
+	Allowlist  []string            `yaml:"allowlist,omitempty"`
+	Groups     []DomainGroupConfig `yaml:"groups,omitempty"`
+	MaxDomains int                 `yaml:"max_domains,omitempty"`
+}
+
+func (cfg *DeliveryDomainsConfig) UnmarshalYAML(value *yaml.Node) error {
+	type plain DeliveryDomainsConfig
+	if err := value.Decode((*plain)(cfg)); err != nil {
+		return err
+	}
+	if !cfg.Relay && !cfg.Recipient {
+		return errors.New("neither relay nor recipient domains enabled")
+	}
+	for i, domain := range cfg.Allowlist {
+		domain = strings.ToLower(strings.Trim(domain, "."))
+		if domain == "" {
+			return errors.New("empty domain")
+		}
+		cfg.Allowlist[i] = domain
+	}
+	if cfg.MaxDomains < 0 {
+		return errors.New("negative max domains")
+	}
+	return nil
+}
+
+type DomainGroupConfig struct {
+	Regexp *Regexp `yaml:"regexp"`
+	Name   string  `yaml:"name"`
+}
+
+func (cfg *DomainGroupConfig) UnmarshalYAML(value *yaml.Node) error {
+	type plain DomainGroupConfig
+	if err := value.Decode((*plain)(cfg)); err != nil {
+		return err
+	}
+	if cfg.Regexp == nil {
+		return errors.New("missing regexp for domain group " + strconv.Quote(cfg.Name))
+	}
+	// The regexp must match the whole domain, not its part.
+	cfg.Regexp = &Regexp{regexp.MustCompile("^(?:" + cfg.Regexp.String() + ")$")}
+	if cfg.Name == "" {
+		return errors.New("empty domain group name")
+	}
+	return nil
+}
+
+type DelayMetricsConfig struct {
+	Type                            DelayMetricType `yaml:"type,omitempty"`
+	Buckets                         []float64       `yaml:"buckets,omitempty"`
+	NativeHistogramBucketFactor     float64         `yaml:"native_histogram_bucket_factor,omitempty"`
+	NativeHistogramMaxBucketNumber  uint32          `yaml:"native_histogram_max_bucket_number,omitempty"`
+	NativeHistogramMinResetDuration time.Duration   `yaml:"native_histogram_min_reset_duration,omitempty"`
+}
+
+func (cfg *DelayMetricsConfig) UnmarshalYAML(value *yaml.Node) error {
+	type plain DelayMetricsConfig
+	if err := value.Decode((*plain)(cfg)); err != nil {
+		return err
+	}
+	for i := 1; i < len(cfg.Buckets); i++ {
+		if cfg.Buckets[i] <= cfg.Buckets[i-1] {
+			return errors.New("buckets not in increasing order")
+		}
+	}
+	if cfg.NativeHistogramBucketFactor != 0 && cfg.NativeHistogramBucketFactor <= 1 {
+		return errors.New("native histogram bucket factor not greater than 1")
+	}
+	return nil
+}
+
+type DelayMetricType int
+
+func (t *DelayMetricType) UnmarshalYAML(value *yaml.Node) error {
+	var s string
+	if err := value.Decode(&s); err != nil {
+		return err
+	}
+	switch s {
+	case "", "summary":
+		*t = DelayMetricTypeSummary
+	case "histogram":
+		*t = DelayMetricTypeHistogram
+	case "native_histogram":
+		*t = DelayMetricTypeNativeHistogram
+	default:
+		return errors.New("unsupported delay metric type " + strconv.Quote(s))
+	}
+	return nil
+}
+
+// DelayMetricType types.
+const (
+	DelayMetricTypeSummary DelayMetricType = iota
+	DelayMetricTypeHistogram
+	DelayMetricTypeNativeHistogram
+)
+
+var (
+	reMetricName = regexp.MustCompile(`^[a-zA-Z_:][a-zA-Z0-9_:]*$`)
+	reLabelName  = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+)
+
+type CustomMetricConfig struct {
+	Name        string           `yaml:"name"`
+	Help        string           `yaml:"help"`
+	Type        CustomMetricType `yaml:"type,omitempty"`
+	Programs    []string         `yaml:"programs,omitempty"`
+	Subprograms []string         `yaml:"subprograms,omitempty"`
+	Regexp      *Regexp          `yaml:"regexp"`
+	Value       string           `yaml:"value,omitempty"`
+	Buckets     []float64        `yaml:"buckets,omitempty"`
+}
+
+func (cfg *CustomMetricConfig) UnmarshalYAML(value *yaml.Node) error {
+	type plain CustomMetricConfig
+	if err := value.Decode((*plain)(cfg)); err != nil {
+		return err
+	}
+	if !reMetricName.MatchString(cfg.Name) {
+		return errors.New("invalid custom metric name " + strconv.Quote(cfg.Name))
+	}
+	if cfg.Help == "" {
+		return errors.New("empty help for custom metric " + strconv.Quote(cfg.Name))
+	}
+	if cfg.Regexp == nil {
+		return errors.New("missing regexp for custom metric " + strconv.Quote(cfg.Name))
+	}
+	labels := map[string]bool{}
+	for _, name := range cfg.Regexp.SubexpNames()[1:] {
+		if name == "" || name == cfg.Value {
+			continue
+		}
+		if !reLabelName.MatchString(name) || strings.HasPrefix(name, "__") {
+			return errors.New("invalid label name " + strconv.Quote(name) + " for custom metric " + strconv.Quote(cfg.Name))
+		}
+		if name == "postfix_instance" && len(cfg.Programs) == 0 {
+			return errors.New("reserved label name " + strconv.Quote(name) + " for custom metric " + strconv.Quote(cfg.Name))
+		}
+		if labels[name] {
+			return errors.New("duplicate label name " + strconv.Quote(name) + " for custom metric " + strconv.Quote(cfg.Name))
+		}
+		labels[name] = true
+	}
+	if cfg.Value != "" && cfg.Regexp.SubexpIndex(cfg.Value) == -1 {
+		return errors.New("unknown value group " + strconv.Quote(cfg.Value) + " for custom metric " + strconv.Quote(cfg.Name))
+	}
+	if cfg.Type != CustomMetricTypeCounter && cfg.Value == "" {
+		return errors.New("missing value group for custom metric " + strconv.Quote(cfg.Name))
+	}
+	if cfg.Type != CustomMetricTypeHistogram && len(cfg.Buckets) > 0 {
+		return errors.New("buckets set for non-histogram custom metric " + strconv.Quote(cfg.Name))
+	}
+	for i := 1; i < len(cfg.Buckets); i++ {
+		if cfg.Buckets[i] <= cfg.Buckets[i-1] {
+			return errors.New("buckets not in increasing order for custom metric " + strconv.Quote(cfg.Name))
+		}
+	}
+	return nil
+}
+
+type CustomMetricType int
+
+func (t *CustomMetricType) UnmarshalYAML(value *yaml.Node) error {
+	var s string
+	if err := value.Decode(&s); err != nil {
+		return err
+	}
+	switch s {
+	case "counter":
+		*t = CustomMetricTypeCounter
+	case "gauge":
+		*t = CustomMetricTypeGauge
+	case "histogram":
+		*t = CustomMetricTypeHistogram
+	default:
+		return errors.New("unsupported custom metric type " + strconv.Quote(s))
+	}
+	return nil
+}
+
+// CustomMetricType types.
+const (
+	CustomMetricTypeCounter CustomMetricType = iota
+	CustomMetricTypeGauge
+	CustomMetricTypeHistogram
+)
+
 type MatchType int
 
 func (t *MatchType) UnmarshalYAML(value *yaml.Node) error {
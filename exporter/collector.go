@@ -2,9 +2,12 @@ package exporter
 
 import (
 	"errors"
+	"os"
 	"strconv"
 	"strings"
 	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
 )
 
 // Collector provides records or errors from parsing Postfix logs.
@@ -14,6 +17,12 @@ type Collector interface {
 	Close() error
 }
 
+// metricsCollector is implemented by collectors exporting their own metrics.
+type metricsCollector interface {
+	describeMetrics(ch chan<- *prometheus.Desc)
+	collectMetrics(ch chan<- prometheus.Metric)
+}
+
 type result struct {
 	rec record
 	err error
@@ -122,3 +131,12 @@ func parseRecord(line string) (record, error) {
 	r.Text = s
 	return r, nil
 }
+
+// writeStateFile atomically replaces the named file with data.
+func writeStateFile(name string, data []byte) error {
+	tmp := name + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, name)
+}
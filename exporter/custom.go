@@ -0,0 +1,120 @@
+package exporter
+
+import (
+	"errors"
+	"regexp"
+	"slices"
+	"strconv"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sergeymakinen/postfix_exporter/v2/config"
+)
+
+// customMetric is a metric defined by a user rule in the config.
+type customMetric struct {
+	cfg       config.CustomMetricConfig
+	labels    []int
+	value     int
+	collector prometheus.Collector
+	observe   func(labels []string, v float64)
+}
+
+func newCustomMetric(cfg config.CustomMetricConfig) *customMetric {
+	m := &customMetric{
+		cfg:   cfg,
+		value: -1,
+	}
+	var names []string
+	if len(cfg.Programs) == 0 {
+		// The rule matches Postfix instances.
+		names = append(names, "postfix_instance")
+	}
+	for i, name := range cfg.Regexp.SubexpNames() {
+		if i == 0 || name == "" {
+			continue
+		}
+		if name == cfg.Value {
+			m.value = i
+			continue
+		}
+		names = append(names, name)
+		m.labels = append(m.labels, i)
+	}
+	switch cfg.Type {
+	case config.CustomMetricTypeGauge:
+		vec := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: cfg.Name,
+			Help: cfg.Help,
+		}, names)
+		m.collector = vec
+		m.observe = func(labels []string, v float64) { vec.WithLabelValues(labels...).Set(v) }
+	case config.CustomMetricTypeHistogram:
+		vec := prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    cfg.Name,
+			Help:    cfg.Help,
+			Buckets: cfg.Buckets,
+		}, names)
+		m.collector = vec
+		m.observe = func(labels []string, v float64) { vec.WithLabelValues(labels...).Observe(v) }
+	default:
+		vec := prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: cfg.Name,
+			Help: cfg.Help,
+		}, names)
+		m.collector = vec
+		m.observe = func(labels []string, v float64) { vec.WithLabelValues(labels...).Add(v) }
+	}
+	return m
+}
+
+// describer is a prometheus.Collector only describing metrics.
+type describer func(ch chan<- *prometheus.Desc)
+
+// Describe implements prometheus.Collector.
+func (d describer) Describe(ch chan<- *prometheus.Desc) { d(ch) }
+
+// Collect implements prometheus.Collector.
+func (d describer) Collect(chan<- prometheus.Metric) {}
+
+// matches reports whether the rule applies to the record.
+func (m *customMetric) matches(r record, instance *regexp.Regexp) bool {
+	if len(m.cfg.Programs) > 0 {
+		if !slices.Contains(m.cfg.Programs, r.Program) {
+			return false
+		}
+	} else if !instance.MatchString(r.Program) {
+		return false
+	}
+	return len(m.cfg.Subprograms) == 0 || slices.Contains(m.cfg.Subprograms, r.Subprogram)
+}
+
+// process updates the metric if the record matches the rule.
+// It returns an error if the captured value is not a valid number.
+func (m *customMetric) process(r record, instance *regexp.Regexp) (bool, error) {
+	if !m.matches(r, instance) {
+		return false, nil
+	}
+	matches := m.cfg.Regexp.FindStringSubmatch(r.Text)
+	if matches == nil {
+		return false, nil
+	}
+	v := 1.0
+	if m.value != -1 {
+		var err error
+		if v, err = strconv.ParseFloat(matches[m.value], 64); err != nil {
+			return true, err
+		}
+		if m.cfg.Type == config.CustomMetricTypeCounter && v < 0 {
+			return true, errors.New("negative counter value " + strconv.Quote(matches[m.value]))
+		}
+	}
+	var labels []string
+	if len(m.cfg.Programs) == 0 {
+		labels = append(labels, r.Program)
+	}
+	for _, i := range m.labels {
+		labels = append(labels, matches[i])
+	}
+	m.observe(labels, v)
+	return true, nil
+}
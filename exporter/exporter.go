@@ -5,10 +5,14 @@ import (
 	"cmp"
 	"errors"
 	"log/slog"
+	"path"
+	"reflect"
 	"regexp"
+	"slices"
 	"strconv"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/sergeymakinen/postfix_exporter/v2/config"
@@ -20,12 +24,17 @@ const namespace = "postfix"
 // is not currently supported.
 var ErrUnsupportedCollector = errors.New("unsupported collector")
 
+// Delivery stages of the delays= field.
+var delayStages = []string{"before_qmgr", "qmgr", "connection_setup", "transmission"}
+
+var delayBuckets = []float64{0.01, 0.05, 0.1, 0.5, 1, 5, 10, 30, 60, 300, 600, 1800, 3600}
+
 var (
 	ipAddrPart = `[a-f0-9:.]+`
 
 	psIPAddrPart       = `\[` + ipAddrPart + `]`
 	rePsConnect        = regexp.MustCompile(`^CONNECT from ` + psIPAddrPart)
-	rePsDNS            = regexp.MustCompile(`^DNSBL rank \d+ for ` + psIPAddrPart)
+	rePsDNS            = regexp.MustCompile(`^DNSBL rank (\d+) for ` + psIPAddrPart)
 	rePsPregreet       = regexp.MustCompile(`^PREGREET \d+ after [\d.]+ from ` + psIPAddrPart)
 	rePsPass           = regexp.MustCompile(`^PASS (OLD|NEW) ` + psIPAddrPart)
 	rePsDisconnect     = regexp.MustCompile(`^DISCONNECT ` + psIPAddrPart)
@@ -43,53 +52,231 @@ var (
 	rePsListed         = regexp.MustCompile(`^(DENYLISTED|BLACKLISTED|ALLOWLISTED|WHITELISTED) ` + psIPAddrPart)
 	rePsVeto           = regexp.MustCompile(`^(ALLOWLIST|WHITELIST) VETO ` + psIPAddrPart)
 
+	reDnsblogListed = regexp.MustCompile(`^addr ` + ipAddrPart + ` listed by domain (\S+) as (\S+)$`)
+
 	hostnamePart           = `[a-zA-Z0-9-._]+`
 	hostnameWithIPAddrPart = hostnamePart + `\[` + ipAddrPart + `]`
 	reHostnameNotResolve   = regexp.MustCompile(`^hostname ` + hostnamePart + ` does not resolve to address ` + ipAddrPart)
 	reConnect              = regexp.MustCompile(`^connect from ` + hostnameWithIPAddrPart)
-	reDisconnect           = regexp.MustCompile(`^disconnect from ` + hostnameWithIPAddrPart)
+	reDisconnect           = regexp.MustCompile(`^disconnect from ` + hostnameWithIPAddrPart + `(.*)$`)
+	reCommandStats         = regexp.MustCompile(`^([a-z]+)=(\d+)(?:/(\d+))?$`)
 	reLostConnection       = regexp.MustCompile(`^lost connection after (.+?) from ` + hostnameWithIPAddrPart)
-	reMilter               = regexp.MustCompile(`^.+?: milter-([a-z-]+): .+? from ` + hostnameWithIPAddrPart)
+	reTimeout              = regexp.MustCompile(`^timeout after (.+?) from ` + hostnameWithIPAddrPart)
+	reTooManyErrors        = regexp.MustCompile(`^too many errors after (.+?) from ` + hostnameWithIPAddrPart)
+	reStage                = regexp.MustCompile(`^[A-Z][A-Z-]*`)
+	reMilter               = regexp.MustCompile(`^.+?: milter-([a-z-]+): (.+?) from ` + hostnameWithIPAddrPart + `(?:: ([^;]*))?`)
+	reMilterReply          = regexp.MustCompile(`^(?:(\d{3}) )?(?:(\d\.\d{1,3}\.\d{1,3}) )?(.*)$`)
+	reMilterError          = regexp.MustCompile(`^(?:milter (\S+): (.+)|connect to Milter service (\S+): (.+))$`)
 	reLoginFailed          = regexp.MustCompile(`^` + hostnameWithIPAddrPart + `: SASL (.+?) authentication failed:`)
+	reSASLLogin            = regexp.MustCompile(`, sasl_method=([^,]+)`)
+	reSASLUsername         = regexp.MustCompile(`, sasl_username=([^,]+)`)
 	reNoqueueReject        = regexp.MustCompile(`^NOQUEUE: reject: (\w+) from ` + hostnameWithIPAddrPart + `: (\d+) ([\d.]+) (<[^>]+>: )?([^;]+); `)
+	reTLSConnection        = regexp.MustCompile(`^(Anonymous|Untrusted|Trusted|Verified) TLS connection established (from|to) ` + hostnameWithIPAddrPart + `(?::\d+)?: (\S+) with cipher (\S+)`)
+	reTLSHandshakeFailed   = regexp.MustCompile(`^(?:SSL_accept error from |SSL_connect error to |TLS library problem: )`)
 
 	reQueueStatus = regexp.MustCompile(`delay=(-?[\d.]+).+status=([a-z-]+) \((.+?)\)$`)
 	reQmgrStatus  = regexp.MustCompile(`status=([a-z-]+), .+?$`)
+	reDelays      = regexp.MustCompile(`, delays=([\d.]+)/([\d.]+)/([\d.]+)/([\d.]+), `)
+	reDSN         = regexp.MustCompile(`, dsn=(\d\.\d{1,3}\.\d{1,3}), `)
+	reRelay       = regexp.MustCompile(`, relay=([^,]+), `)
+	reTo          = regexp.MustCompile(`: to=<([^>]*)>, `)
+
+	reQueueID          = regexp.MustCompile(`^(\w+): `)
+	reSmtpdClient      = regexp.MustCompile(`^(\w+): client=` + hostnameWithIPAddrPart)
+	rePickup           = regexp.MustCompile(`^(\w+): uid=\d+ from=<`)
+	reCleanupMessageID = regexp.MustCompile(`^(\w+): (?:resent-)?message-id=`)
+	reQmgrActive       = regexp.MustCompile(`^(\w+): from=<[^>]*>, size=(\d+), nrcpt=(\d+) \(queue active\)$`)
+	reQmgrRemoved      = regexp.MustCompile(`^(\w+): removed$`)
+
+	reMasterStarted      = regexp.MustCompile(`^daemon started -- version ([^,]+)`)
+	reMasterReload       = regexp.MustCompile(`^reload -- version ([^,]+)`)
+	reMasterTerminating  = regexp.MustCompile(`^terminating on signal \d+`)
+	reProcessExit        = regexp.MustCompile(`^process (\S+) pid \d+ (?:exit status (\d+)|killed by signal (\d+))`)
+	reProcessLimit       = regexp.MustCompile(`^service "([^"]+)" \([^)]*\) has reached its process limit`)
+	reBounceNotification = regexp.MustCompile(`^(\w+): (sender|postmaster) (non-delivery|delay|delivery status) notification: (\w+)$`)
 
 	hostSaidPart      = `host ` + hostnameWithIPAddrPart + ` said: (.+) \(in reply to \w+[\w /-]*\)`
 	reHostSaid        = regexp.MustCompile(hostSaidPart)
 	reHostReplyStatus = regexp.MustCompile(`^(\d{3})(.{1,3}(\d\.\d\.\d)|[^ ]+|) (.+)$`)
 	reSmtpHostSaid    = regexp.MustCompile(`^\w+: ` + hostSaidPart + `$`)
+
+	reConnectFailed      = regexp.MustCompile(`^(?:\w+: )?connect to (` + hostnamePart + `)\[(` + ipAddrPart + `)](?::\d+)?: (.+)$`)
+	reConversationFailed = regexp.MustCompile(`^(?:\w+: )?(?:conversation|lost connection) with (` + hostnamePart + `)\[(` + ipAddrPart + `)] (.+)$`)
+	reHostNotFound       = regexp.MustCompile(`^(?:\w+: )?(?:Host or domain name not found\. Name service error for name=(\S+) type=\w+: (.+)|unable to look up host (\S+): (.+))$`)
 )
 
 // Exporter collects Postfix stats from logs and exports them
 // using the prometheus metrics package.
 type Exporter struct {
 	ch        chan result
+	flush     chan struct{}
+	reload    chan reloadRequest
 	done      chan struct{}
 	collector Collector
 	wg        sync.WaitGroup
-	instance  string
+	instance  *regexp.Regexp
 	logger    *slog.Logger
 	config    *config.Config
+	tracker   *tracker
+	custom    []*customMetric
+	usernames *labelLimiter
+	relays    *domainLimiter
+	domains   *domainLimiter
+
+	// sasl holds smtpd sessions by process with a counted SASL login.
+	sasl map[string]bool
 
 	errors               prometheus.Counter
 	foreign              prometheus.Counter
-	unsupported          prometheus.Counter
+	unsupported          *prometheus.CounterVec
 	postscreen           *prometheus.CounterVec
+	dnsblRanks           *prometheus.HistogramVec
+	dnsblListings        *prometheus.CounterVec
 	connects             *prometheus.CounterVec
 	disconnects          *prometheus.CounterVec
+	commands             *prometheus.CounterVec
+	sessionCommands      *prometheus.HistogramVec
+	acceptedMessages     *prometheus.CounterVec
+	acceptedRecipients   *prometheus.CounterVec
 	lostConnections      *prometheus.CounterVec
+	timeouts             *prometheus.CounterVec
+	tooManyErrors        *prometheus.CounterVec
 	hostnameNotResolved  *prometheus.CounterVec
 	statuses             *prometheus.CounterVec
-	delays               *prometheus.SummaryVec
+	delays               prometheus.ObserverVec
+	stageDelays          prometheus.ObserverVec
 	statusReplies        *prometheus.CounterVec
+	pipeDeliveries       *prometheus.CounterVec
 	smtpReplies          *prometheus.CounterVec
 	milter               *prometheus.CounterVec
+	milterReplies        *prometheus.CounterVec
+	milterErrors         *prometheus.CounterVec
 	loginFailed          *prometheus.CounterVec
+	saslLogins           *prometheus.CounterVec
+	saslUserLogins       *prometheus.CounterVec
 	qmgrStatuses         *prometheus.CounterVec
 	logs                 *prometheus.CounterVec
 	noqueueRejectReplies *prometheus.CounterVec
+	noqueueRejects       *prometheus.CounterVec
+	tlsConnections       *prometheus.CounterVec
+	tlsHandshakeFailures *prometheus.CounterVec
+	messageSizes         *prometheus.HistogramVec
+	messageRecipients    *prometheus.HistogramVec
+	messageQueueTimes    *prometheus.HistogramVec
+	trackerEvictions     prometheus.Counter
+
+	notifications          *prometheus.CounterVec
+	notificationDeliveries *prometheus.CounterVec
+	masterEvents           *prometheus.CounterVec
+	masterInfo             *prometheus.GaugeVec
+	processExits           *prometheus.CounterVec
+	processLimits          *prometheus.CounterVec
+	connectionFailures     *prometheus.CounterVec
+	domainStatuses         *prometheus.CounterVec
+}
+
+// Wait waits for the collector to finish collecting logs
+// and for the collected logs to be processed.
+func (e *Exporter) Wait() {
+	e.collector.Wait()
+	select {
+	case e.flush <- struct{}{}:
+	case <-e.done:
+	}
+}
+
+type reloadRequest struct {
+	cfg *config.Config
+	err chan error
+}
+
+// Reload replaces the config used to process logs. The config is swapped
+// between log records, and on error the current config is kept.
+// Delay and custom metrics can't be changed without a restart.
+func (e *Exporter) Reload(cfg *config.Config) error {
+	req := reloadRequest{
+		cfg: cmp.Or(cfg, &config.Config{}),
+		err: make(chan error, 1),
+	}
+	select {
+	case e.reload <- req:
+		return <-req.err
+	case <-e.done:
+		return errors.New("exporter is closed")
+	}
+}
+
+func (e *Exporter) applyConfig(cfg *config.Config) error {
+	if !reflect.DeepEqual(cfg.DelayMetrics, e.config.DelayMetrics) {
+		return errors.New("delay metrics can't be changed without a restart")
+	}
+	if !slices.EqualFunc(cfg.CustomMetrics, e.config.CustomMetrics, func(a, b config.CustomMetricConfig) bool {
+		return a.Regexp.String() == b.Regexp.String() && reflect.DeepEqual(withoutRegexp(a), withoutRegexp(b))
+	}) {
+		return errors.New("custom metrics can't be changed without a restart")
+	}
+	if !reflect.DeepEqual(cfg.SASLUsernames, e.config.SASLUsernames) {
+		usernames := newUsernameLimiter(cfg.SASLUsernames)
+		if usernames != nil {
+			usernames.carry(e.usernames, nil)
+		}
+		e.usernames = usernames
+	}
+	if !deliveryDomainsEqual(cfg.DeliveryDomains, e.config.DeliveryDomains) {
+		relays, domains := newDeliveryDomainLimiters(cfg.DeliveryDomains)
+		if relays != nil {
+			relays.carry(e.relays)
+		}
+		if domains != nil {
+			domains.carry(e.domains)
+		}
+		e.relays, e.domains = relays, domains
+	}
+	e.tracker.maxMessages = cmp.Or(cfg.MessageTracking.MaxMessages, 10000)
+	e.tracker.ttl = cmp.Or(cfg.MessageTracking.TTL, 5*24*time.Hour)
+	e.config = cfg
+	return nil
+}
+
+// newDeliveryDomainLimiters returns relay and recipient domain limiters, nil if disabled.
+func newDeliveryDomainLimiters(cfg *config.DeliveryDomainsConfig) (relays, domains *domainLimiter) {
+	if cfg == nil {
+		return nil, nil
+	}
+	max := cmp.Or(cfg.MaxDomains, 50)
+	if cfg.Relay {
+		relays = newDomainLimiter(cfg, max)
+	}
+	if cfg.Recipient {
+		domains = newDomainLimiter(cfg, max)
+	}
+	return relays, domains
+}
+
+func deliveryDomainsEqual(a, b *config.DeliveryDomainsConfig) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return a.Relay == b.Relay &&
+		a.Recipient == b.Recipient &&
+		a.MaxDomains == b.MaxDomains &&
+		slices.Equal(a.Allowlist, b.Allowlist) &&
+		slices.EqualFunc(a.Groups, b.Groups, func(a, b config.DomainGroupConfig) bool {
+			return a.Regexp.String() == b.Regexp.String() && a.Name == b.Name
+		})
+}
+
+// newUsernameLimiter returns a SASL username limiter or nil if disabled.
+func newUsernameLimiter(cfg *config.SASLUsernamesConfig) *labelLimiter {
+	if cfg == nil {
+		return nil
+	}
+	return newLabelLimiter(cfg.Allowlist, cfg.Hash, cmp.Or(cfg.MaxUsernames, 100))
+}
+
+func withoutRegexp(cfg config.CustomMetricConfig) config.CustomMetricConfig {
+	cfg.Regexp = nil
+	return cfg
 }
 
 // Close stops collecting new logs.
@@ -103,23 +290,64 @@ func (e *Exporter) Close() error {
 // Describe describes all the metrics exported by the Postfix exporter. It
 // implements prometheus.Collector.
 func (e *Exporter) Describe(ch chan<- *prometheus.Desc) {
+	e.describeBuiltin(ch)
+	if c, ok := e.collector.(metricsCollector); ok {
+		c.describeMetrics(ch)
+	}
+	for _, m := range e.custom {
+		m.collector.Describe(ch)
+	}
+}
+
+// describeBuiltin describes all the metrics exported by the Postfix exporter
+// except custom metrics and metrics of the collector.
+func (e *Exporter) describeBuiltin(ch chan<- *prometheus.Desc) {
 	e.errors.Describe(ch)
 	e.foreign.Describe(ch)
 	e.unsupported.Describe(ch)
 	e.postscreen.Describe(ch)
+	e.dnsblRanks.Describe(ch)
+	e.dnsblListings.Describe(ch)
 	e.connects.Describe(ch)
 	e.disconnects.Describe(ch)
+	e.commands.Describe(ch)
+	e.sessionCommands.Describe(ch)
+	e.acceptedMessages.Describe(ch)
+	e.acceptedRecipients.Describe(ch)
 	e.lostConnections.Describe(ch)
+	e.timeouts.Describe(ch)
+	e.tooManyErrors.Describe(ch)
 	e.hostnameNotResolved.Describe(ch)
 	e.statuses.Describe(ch)
 	e.delays.Describe(ch)
+	e.stageDelays.Describe(ch)
 	e.statusReplies.Describe(ch)
+	e.pipeDeliveries.Describe(ch)
 	e.smtpReplies.Describe(ch)
 	e.milter.Describe(ch)
+	e.milterReplies.Describe(ch)
+	e.milterErrors.Describe(ch)
 	e.loginFailed.Describe(ch)
+	e.saslLogins.Describe(ch)
+	e.saslUserLogins.Describe(ch)
 	e.qmgrStatuses.Describe(ch)
 	e.logs.Describe(ch)
 	e.noqueueRejectReplies.Describe(ch)
+	e.noqueueRejects.Describe(ch)
+	e.tlsConnections.Describe(ch)
+	e.tlsHandshakeFailures.Describe(ch)
+	e.messageSizes.Describe(ch)
+	e.messageRecipients.Describe(ch)
+	e.messageQueueTimes.Describe(ch)
+	e.trackerEvictions.Describe(ch)
+	e.notifications.Describe(ch)
+	e.notificationDeliveries.Describe(ch)
+	e.masterEvents.Describe(ch)
+	e.masterInfo.Describe(ch)
+	e.processExits.Describe(ch)
+	e.processLimits.Describe(ch)
+	e.connectionFailures.Describe(ch)
+	e.domainStatuses.Describe(ch)
 }
 
 // Collect delivers collected Postfix statistics as Prometheus metrics.
@@ -129,19 +357,54 @@ func (e *Exporter) Collect(ch chan<- prometheus.Metric) {
 	e.foreign.Collect(ch)
 	e.unsupported.Collect(ch)
 	e.postscreen.Collect(ch)
+	e.dnsblRanks.Collect(ch)
+	e.dnsblListings.Collect(ch)
 	e.connects.Collect(ch)
 	e.disconnects.Collect(ch)
+	e.commands.Collect(ch)
+	e.sessionCommands.Collect(ch)
+	e.acceptedMessages.Collect(ch)
+	e.acceptedRecipients.Collect(ch)
 	e.lostConnections.Collect(ch)
+	e.timeouts.Collect(ch)
+	e.tooManyErrors.Collect(ch)
 	e.hostnameNotResolved.Collect(ch)
 	e.statuses.Collect(ch)
 	e.delays.Collect(ch)
+	e.stageDelays.Collect(ch)
 	e.statusReplies.Collect(ch)
+	e.pipeDeliveries.Collect(ch)
 	e.smtpReplies.Collect(ch)
 	e.milter.Collect(ch)
+	e.milterReplies.Collect(ch)
+	e.milterErrors.Collect(ch)
 	e.loginFailed.Collect(ch)
+	e.saslLogins.Collect(ch)
+	e.saslUserLogins.Collect(ch)
 	e.qmgrStatuses.Collect(ch)
 	e.logs.Collect(ch)
 	e.noqueueRejectReplies.Collect(ch)
+	e.noqueueRejects.Collect(ch)
+	e.tlsConnections.Collect(ch)
+	e.tlsHandshakeFailures.Collect(ch)
+	e.messageSizes.Collect(ch)
+	e.messageRecipients.Collect(ch)
+	e.messageQueueTimes.Collect(ch)
+	e.trackerEvictions.Collect(ch)
+	e.notifications.Collect(ch)
+	e.notificationDeliveries.Collect(ch)
+	e.masterEvents.Collect(ch)
+	e.masterInfo.Collect(ch)
+	e.processExits.Collect(ch)
+	e.processLimits.Collect(ch)
+	e.connectionFailures.Collect(ch)
+	e.domainStatuses.Collect(ch)
+	for _, m := range e.custom {
+		m.collector.Collect(ch)
+	}
+	if c, ok := e.collector.(metricsCollector); ok {
+		c.collectMetrics(ch)
+	}
 }
 
 func (e *Exporter) process(r record, err error) {
@@ -150,48 +413,63 @@ func (e *Exporter) process(r record, err error) {
 		e.logger.Debug("Error parsing log record", "record", r, "err", err)
 		return
 	}
-	if r.Program != e.instance {
+	e.tracker.advance(r.Time)
+	custom := false
+	for _, m := range e.custom {
+		ok, err := m.process(r, e.instance)
+		if err != nil {
+			e.logger.Warn("Error parsing custom metric value", "record", r, "metric", m.cfg.Name, "err", err)
+		}
+		custom = custom || ok
+	}
+	if !e.instance.MatchString(r.Program) {
+		if custom {
+			return
+		}
 		e.foreign.Inc()
 		e.logger.Debug("Foreign log record", "record", r)
 		return
 	}
-	e.logs.WithLabelValues(r.Subprogram, string(r.Severity)).Inc()
-	parseStatusReply := func(matches []string) {
-		reply, err := parseHostReply(matches[3])
-		if err == nil {
-			match := func(typ config.MatchType) string {
-				switch typ {
-				case config.MatchTypeCode:
-					return reply.Code
-				case config.MatchTypeEnhancedCode:
-					return reply.EnhancedCode
-				default:
-					return reply.Text
-				}
+	e.logs.WithLabelValues(r.Program, r.Subprogram, string(r.Severity)).Inc()
+	observeStatusReply := func(agent, status string, reply *hostReply) {
+		match := func(typ config.MatchType) string {
+			switch typ {
+			case config.MatchTypeCode:
+				return reply.Code
+			case config.MatchTypeEnhancedCode:
+				return reply.EnhancedCode
+			default:
+				return reply.Text
 			}
-			if cfg, m := findSubmatch(e.config.StatusReplies, func(cfg config.StatusReplyMatchConfig) []int {
-				if len(cfg.Statuses) > 0 {
-					found := false
-					for _, status := range cfg.Statuses {
-						if status == matches[2] {
-							found = true
-							break
-						}
-					}
-					if !found {
-						return nil
+		}
+		if cfg, m := findSubmatch(e.config.StatusReplies, func(cfg config.StatusReplyMatchConfig) []int {
+			if len(cfg.Statuses) > 0 {
+				found := false
+				for _, s := range cfg.Statuses {
+					if s == status {
+						found = true
+						break
 					}
 				}
-				for _, status := range cfg.NotStatuses {
-					if status == matches[2] {
-						return nil
-					}
+				if !found {
+					return nil
 				}
-				return cfg.Regexp.FindStringSubmatchIndex(match(cfg.Match))
-			}); m != nil {
-				text := string(cfg.Regexp.ExpandString(nil, cfg.Text, match(cfg.Match), m))
-				e.statusReplies.WithLabelValues(r.Subprogram, matches[2], reply.Code, reply.EnhancedCode, text).Inc()
 			}
+			for _, s := range cfg.NotStatuses {
+				if s == status {
+					return nil
+				}
+			}
+			return cfg.Regexp.FindStringSubmatchIndex(match(cfg.Match))
+		}); m != nil {
+			text := string(cfg.Regexp.ExpandString(nil, cfg.Text, match(cfg.Match), m))
+			e.statusReplies.WithLabelValues(r.Program, r.Subprogram, agent, status, reply.Code, reply.EnhancedCode, text).Inc()
+		}
+	}
+	parseStatusReply := func(agent string, matches []string) {
+		reply, err := parseHostReply(matches[3])
+		if err == nil {
+			observeStatusReply(agent, matches[2], reply)
 		} else {
 			e.logger.Warn("Error parsing host reply", "record", r, "err", err)
 		}
@@ -199,41 +477,49 @@ func (e *Exporter) process(r record, err error) {
 	found := true
 	if r.Subprogram == "postscreen" {
 		if matches := rePsConnect.FindStringSubmatch(r.Text); matches != nil {
-			e.postscreen.WithLabelValues("CONNECT").Inc()
+			e.postscreen.WithLabelValues(r.Program, "CONNECT").Inc()
 		} else if matches := rePsDNS.FindStringSubmatch(r.Text); matches != nil {
-			e.postscreen.WithLabelValues("DNSBL").Inc()
+			e.postscreen.WithLabelValues(r.Program, "DNSBL").Inc()
+			f, _ := strconv.ParseFloat(matches[1], 64)
+			e.dnsblRanks.WithLabelValues(r.Program).Observe(f)
 		} else if matches := rePsPregreet.FindStringSubmatch(r.Text); matches != nil {
-			e.postscreen.WithLabelValues("PREGREET").Inc()
+			e.postscreen.WithLabelValues(r.Program, "PREGREET").Inc()
 		} else if matches := rePsPass.FindStringSubmatch(r.Text); matches != nil {
-			e.postscreen.WithLabelValues("PASS " + matches[1]).Inc()
+			e.postscreen.WithLabelValues(r.Program, "PASS "+matches[1]).Inc()
 		} else if matches := rePsDisconnect.FindStringSubmatch(r.Text); matches != nil {
-			e.postscreen.WithLabelValues("DISCONNECT").Inc()
+			e.postscreen.WithLabelValues(r.Program, "DISCONNECT").Inc()
 		} else if matches := rePsHangup.FindStringSubmatch(r.Text); matches != nil {
-			e.postscreen.WithLabelValues("HANGUP").Inc()
+			e.postscreen.WithLabelValues(r.Program, "HANGUP").Inc()
 		} else if matches := rePsNoqueueRcpt.FindStringSubmatch(r.Text); matches != nil {
-			e.postscreen.WithLabelValues("NOQUEUE: RCPT").Inc()
+			e.postscreen.WithLabelValues(r.Program, "NOQUEUE: RCPT").Inc()
 		} else if matches := rePsData.FindStringSubmatch(r.Text); matches != nil {
-			e.postscreen.WithLabelValues("DATA").Inc()
+			e.postscreen.WithLabelValues(r.Program, "DATA").Inc()
 		} else if matches := rePsBdat.FindStringSubmatch(r.Text); matches != nil {
-			e.postscreen.WithLabelValues("BDAT").Inc()
+			e.postscreen.WithLabelValues(r.Program, "BDAT").Inc()
 		} else if matches := rePsCmdTimeLimit.FindStringSubmatch(r.Text); matches != nil {
-			e.postscreen.WithLabelValues("COMMAND TIME LIMIT").Inc()
+			e.postscreen.WithLabelValues(r.Program, "COMMAND TIME LIMIT").Inc()
 		} else if matches := rePsCmdLengthLimit.FindStringSubmatch(r.Text); matches != nil {
-			e.postscreen.WithLabelValues("COMMAND LENGTH LIMIT").Inc()
+			e.postscreen.WithLabelValues(r.Program, "COMMAND LENGTH LIMIT").Inc()
 		} else if matches := rePsBareNewline.FindStringSubmatch(r.Text); matches != nil {
-			e.postscreen.WithLabelValues("BARE NEWLINE").Inc()
+			e.postscreen.WithLabelValues(r.Program, "BARE NEWLINE").Inc()
 		} else if matches := rePsNonSMTPCmd.FindStringSubmatch(r.Text); matches != nil {
-			e.postscreen.WithLabelValues("NON-SMTP COMMAND").Inc()
+			e.postscreen.WithLabelValues(r.Program, "NON-SMTP COMMAND").Inc()
 		} else if matches := rePsCmpPipelining.FindStringSubmatch(r.Text); matches != nil {
-			e.postscreen.WithLabelValues("COMMAND PIPELINING").Inc()
+			e.postscreen.WithLabelValues(r.Program, "COMMAND PIPELINING").Inc()
 		} else if matches := rePsCmdCountLimit.FindStringSubmatch(r.Text); matches != nil {
-			e.postscreen.WithLabelValues("COMMAND COUNT LIMIT").Inc()
+			e.postscreen.WithLabelValues(r.Program, "COMMAND COUNT LIMIT").Inc()
 		} else if matches := rePsNoqueueConnect.FindStringSubmatch(r.Text); matches != nil {
-			e.postscreen.WithLabelValues("NOQUEUE: CONNECT").Inc()
+			e.postscreen.WithLabelValues(r.Program, "NOQUEUE: CONNECT").Inc()
 		} else if matches := rePsListed.FindStringSubmatch(r.Text); matches != nil {
-			e.postscreen.WithLabelValues(matches[1]).Inc()
+			e.postscreen.WithLabelValues(r.Program, matches[1]).Inc()
 		} else if matches := rePsVeto.FindStringSubmatch(r.Text); matches != nil {
-			e.postscreen.WithLabelValues(matches[1] + " VETO").Inc()
+			e.postscreen.WithLabelValues(r.Program, matches[1]+" VETO").Inc()
+		} else {
+			found = false
+		}
+	} else if r.Subprogram == "dnsblog" {
+		if matches := reDnsblogListed.FindStringSubmatch(r.Text); matches != nil {
+			e.dnsblListings.WithLabelValues(r.Program, matches[1], matches[2]).Inc()
 		} else {
 			found = false
 		}
@@ -254,102 +540,429 @@ func (e *Exporter) process(r record, err error) {
 					return cfg.Regexp.FindStringSubmatchIndex(match(cfg.Match))
 				}); m != nil {
 					text := string(cfg.Regexp.ExpandString(nil, cfg.Text, match(cfg.Match), m))
-					e.noqueueRejectReplies.WithLabelValues(r.Subprogram, matches[1], matches[2], matches[3], text).Inc()
+					e.noqueueRejectReplies.WithLabelValues(r.Program, r.Subprogram, matches[1], matches[2], matches[3], text).Inc()
 				}
+				e.noqueueRejects.WithLabelValues(r.Program, r.Subprogram, matches[1], matches[2], e.rejectReason(matches, r.Text[len(matches[0]):])).Inc()
 			} else {
 				found = false
 			}
 		} else if matches := reConnect.FindStringSubmatch(r.Text); matches != nil {
-			e.connects.WithLabelValues(r.Subprogram).Inc()
+			e.connects.WithLabelValues(r.Program, r.Subprogram).Inc()
 		} else if matches := reDisconnect.FindStringSubmatch(r.Text); matches != nil {
-			e.disconnects.WithLabelValues(r.Subprogram).Inc()
+			e.disconnects.WithLabelValues(r.Program, r.Subprogram).Inc()
+			e.observeCommands(r, matches[1])
+			delete(e.sasl, smtpdSession(r))
 		} else if matches := reLostConnection.FindStringSubmatch(r.Text); matches != nil {
-			e.lostConnections.WithLabelValues(r.Subprogram).Inc()
+			e.lostConnections.WithLabelValues(r.Program, r.Subprogram, parseStage(matches[1])).Inc()
+		} else if matches := reTimeout.FindStringSubmatch(r.Text); matches != nil {
+			e.timeouts.WithLabelValues(r.Program, r.Subprogram, parseStage(matches[1])).Inc()
+		} else if matches := reTooManyErrors.FindStringSubmatch(r.Text); matches != nil {
+			e.tooManyErrors.WithLabelValues(r.Program, r.Subprogram, parseStage(matches[1])).Inc()
 		} else if matches := reHostnameNotResolve.FindStringSubmatch(r.Text); matches != nil {
-			e.hostnameNotResolved.WithLabelValues(r.Subprogram).Inc()
-		} else if matches := reMilter.FindStringSubmatch(r.Text); matches != nil {
-			e.milter.WithLabelValues(r.Subprogram, matches[1]).Inc()
+			e.hostnameNotResolved.WithLabelValues(r.Program, r.Subprogram).Inc()
 		} else if matches := reLoginFailed.FindStringSubmatch(r.Text); matches != nil {
-			e.loginFailed.WithLabelValues(r.Subprogram, matches[1]).Inc()
-		} else {
+			e.loginFailed.WithLabelValues(r.Program, r.Subprogram, matches[1]).Inc()
+			e.login(r, matches[1], "failed")
+		} else if matches := reSmtpdClient.FindStringSubmatch(r.Text); matches != nil {
+			e.accept(r.Program, matches[1], "smtpd", r.Time).subprogram = r.Subprogram
+			e.acceptedMessages.WithLabelValues(r.Program, r.Subprogram).Inc()
+			// A successful login is logged with every message of the session.
+			if matches := reSASLLogin.FindStringSubmatch(r.Text); matches != nil && !e.sasl[smtpdSession(r)] {
+				e.sasl[smtpdSession(r)] = true
+				e.login(r, matches[1], "succeeded")
+			}
+		} else if !e.processMilter(r) && !e.processTLS(r) {
 			found = false
 		}
-	} else if r.Subprogram == "smtp" {
+	} else if agent := deliveryAgent(r.Subprogram); agent != "" {
 		if matches := reQueueStatus.FindStringSubmatch(r.Text); matches != nil {
-			e.statuses.WithLabelValues(r.Subprogram, matches[2]).Inc()
+			e.statuses.WithLabelValues(r.Program, r.Subprogram, agent, matches[2]).Inc()
 			f, _ := strconv.ParseFloat(matches[1], 64)
-			e.delays.WithLabelValues(r.Subprogram, matches[2]).Observe(f)
-			if m := reHostSaid.FindStringSubmatch(matches[3]); m != nil {
-				reply, err := parseHostReply(m[1])
-				if err == nil {
-					if cfg, m := findSubmatch(e.config.StatusReplies, func(cfg config.StatusReplyMatchConfig) []int {
-						return cfg.Regexp.FindStringSubmatchIndex(reply.Text)
-					}); m != nil {
-						text := string(cfg.Regexp.ExpandString(nil, cfg.Text, reply.Text, m))
-						e.statusReplies.WithLabelValues(r.Subprogram, matches[2], reply.Code, reply.EnhancedCode, text).Inc()
+			e.delays.WithLabelValues(r.Program, r.Subprogram, agent, matches[2]).Observe(f)
+			e.observeStageDelays(r.Program, r.Subprogram, r.Text)
+			e.deliver(r.Program, r.Text, matches[2])
+			e.observeDomains(r, agent, matches[2])
+			if (agent == "smtp" || agent == "lmtp") && (matches[2] == "deferred" || matches[2] == "bounced") {
+				// The last connection failure of a delivery attempt is only logged as the status reason.
+				var domain string
+				if m := reTo.FindStringSubmatch(r.Text); m != nil {
+					domain = recipientDomain(m[1])
+				}
+				e.processConnectionFailure(r, matches[3], domain)
+			}
+			switch agent {
+			case "smtp":
+				if m := reHostSaid.FindStringSubmatch(matches[3]); m != nil {
+					reply, err := parseHostReply(m[1])
+					if err == nil {
+						if cfg, m := findSubmatch(e.config.StatusReplies, func(cfg config.StatusReplyMatchConfig) []int {
+							return cfg.Regexp.FindStringSubmatchIndex(reply.Text)
+						}); m != nil {
+							text := string(cfg.Regexp.ExpandString(nil, cfg.Text, reply.Text, m))
+							e.statusReplies.WithLabelValues(r.Program, r.Subprogram, agent, matches[2], reply.Code, reply.EnhancedCode, text).Inc()
+						}
+					} else {
+						e.logger.Warn("Error parsing host reply", "record", r, "err", err)
 					}
 				} else {
-					e.logger.Warn("Error parsing host reply", "record", r, "err", err)
+					parseStatusReply(agent, matches)
+				}
+			case "lmtp":
+				parseStatusReply(agent, matches)
+			default:
+				// Other delivery agents don't report SMTP replies, only the DSN code and text.
+				reply := &hostReply{Text: matches[3]}
+				if m := reDSN.FindStringSubmatch(r.Text); m != nil {
+					reply.EnhancedCode = m[1]
+				}
+				observeStatusReply(agent, matches[2], reply)
+				if agent == "pipe" {
+					if m := reRelay.FindStringSubmatch(r.Text); m != nil {
+						e.pipeDeliveries.WithLabelValues(r.Program, r.Subprogram, m[1], matches[2]).Inc()
+					}
 				}
-			} else {
-				parseStatusReply(matches)
 			}
-		} else if matches := reSmtpHostSaid.FindStringSubmatch(r.Text); matches != nil {
+		} else if matches := reSmtpHostSaid.FindStringSubmatch(r.Text); agent == "smtp" && matches != nil {
 			reply, err := parseHostReply(matches[1])
 			if err == nil {
 				if cfg, m := findSubmatch(e.config.SmtpReplies, func(cfg config.ReplyMatchConfig) []int {
 					return cfg.Regexp.FindStringSubmatchIndex(reply.Text)
 				}); m != nil {
 					text := string(cfg.Regexp.ExpandString(nil, cfg.Text, reply.Text, m))
-					e.smtpReplies.WithLabelValues(reply.Code, reply.EnhancedCode, text).Inc()
+					e.smtpReplies.WithLabelValues(r.Program, reply.Code, reply.EnhancedCode, text).Inc()
 				}
 			} else {
 				e.logger.Warn("Error parsing host reply", "record", r, "err", err)
 			}
+		} else if agent == "smtp" || agent == "lmtp" {
+			found = e.processConnectionFailure(r, r.Text, "") || e.processTLS(r)
 		} else {
 			found = false
 		}
-	} else if r.Subprogram == "lmtp" {
-		if matches := reQueueStatus.FindStringSubmatch(r.Text); matches != nil {
-			e.statuses.WithLabelValues(r.Subprogram, matches[2]).Inc()
-			f, _ := strconv.ParseFloat(matches[1], 64)
-			e.delays.WithLabelValues(r.Subprogram, matches[2]).Observe(f)
-			parseStatusReply(matches)
+	} else if r.Subprogram == "cleanup" {
+		if matches := reCleanupMessageID.FindStringSubmatch(r.Text); matches != nil {
+			e.accept(r.Program, matches[1], "", r.Time)
+		} else if !e.processMilter(r) {
+			found = false
+		}
+	} else if r.Subprogram == "pickup" {
+		if matches := rePickup.FindStringSubmatch(r.Text); matches != nil {
+			e.accept(r.Program, matches[1], "pickup", r.Time)
 		} else {
 			found = false
 		}
-	} else if r.Subprogram == "cleanup" {
-		if matches := reMilter.FindStringSubmatch(r.Text); matches != nil {
-			e.milter.WithLabelValues(r.Subprogram, matches[1]).Inc()
+	} else if r.Subprogram == "master" {
+		if matches := reMasterStarted.FindStringSubmatch(r.Text); matches != nil {
+			e.masterEvents.WithLabelValues(r.Program, "start").Inc()
+			e.setMasterVersion(r.Program, matches[1])
+		} else if matches := reMasterReload.FindStringSubmatch(r.Text); matches != nil {
+			e.masterEvents.WithLabelValues(r.Program, "reload").Inc()
+			e.setMasterVersion(r.Program, matches[1])
+		} else if reMasterTerminating.MatchString(r.Text) {
+			e.masterEvents.WithLabelValues(r.Program, "stop").Inc()
+		} else if matches := reProcessExit.FindStringSubmatch(r.Text); matches != nil {
+			status := matches[2]
+			if status == "" {
+				status = "signal_" + matches[3]
+			}
+			e.processExits.WithLabelValues(r.Program, path.Base(matches[1]), status).Inc()
+		} else if matches := reProcessLimit.FindStringSubmatch(r.Text); matches != nil {
+			e.processLimits.WithLabelValues(r.Program, matches[1]).Inc()
+		} else {
+			found = false
+		}
+	} else if r.Subprogram == "bounce" {
+		if matches := reBounceNotification.FindStringSubmatch(r.Text); matches != nil {
+			e.notify(r.Program, matches[1], matches[4], matches[2], notificationTypes[matches[3]])
 		} else {
 			found = false
 		}
 	} else if r.Subprogram == "qmgr" {
-		if matches := reQmgrStatus.FindStringSubmatch(r.Text); matches != nil {
-			e.qmgrStatuses.WithLabelValues(matches[1]).Inc()
+		if matches := reQmgrActive.FindStringSubmatch(r.Text); matches != nil {
+			if m := e.tracker.get(r.Program, matches[1], false); m != nil && !m.active {
+				m.active = true
+				m.size, _ = strconv.ParseFloat(matches[2], 64)
+				m.nrcpt, _ = strconv.ParseFloat(matches[3], 64)
+				if m.origin == "smtpd" {
+					e.acceptedRecipients.WithLabelValues(r.Program, m.subprogram).Add(m.nrcpt)
+				}
+			}
+		} else if matches := reQmgrRemoved.FindStringSubmatch(r.Text); matches != nil {
+			e.remove(r.Program, matches[1], r.Time)
+		} else if matches := reQmgrStatus.FindStringSubmatch(r.Text); matches != nil {
+			e.qmgrStatuses.WithLabelValues(r.Program, matches[1]).Inc()
+			e.deliver(r.Program, r.Text, matches[1])
 		} else {
 			found = false
 		}
 	} else {
 		found = false
 	}
-	if found {
+	if found || custom {
 		return
 	}
-	e.unsupported.Inc()
+	e.unsupported.WithLabelValues(r.Program).Inc()
 	e.logger.Debug("Unsupported log record", "record", r)
 }
 
+// observeDomains counts a message status change by relay and recipient domain if enabled.
+func (e *Exporter) observeDomains(r record, agent, status string) {
+	if e.relays == nil && e.domains == nil {
+		return
+	}
+	var relay, domain string
+	if e.relays != nil {
+		if matches := reRelay.FindStringSubmatch(r.Text); matches != nil {
+			host, _, _ := strings.Cut(matches[1], "[")
+			relay = e.relays.value(host)
+		}
+	}
+	if e.domains != nil {
+		if matches := reTo.FindStringSubmatch(r.Text); matches != nil {
+			domain = e.domains.value(recipientDomain(matches[1]))
+		}
+	}
+	e.domainStatuses.WithLabelValues(r.Program, agent, status, relay, domain).Inc()
+}
+
+// processMilter counts milter actions, replies and communication errors.
+// It returns false if the record is not milter related.
+func (e *Exporter) processMilter(r record) bool {
+	if matches := reMilter.FindStringSubmatch(r.Text); matches != nil {
+		e.milter.WithLabelValues(r.Program, r.Subprogram, matches[1]).Inc()
+		reply := reMilterReply.FindStringSubmatch(matches[3])
+		match := func(typ config.MatchType) string {
+			switch typ {
+			case config.MatchTypeCode:
+				return reply[1]
+			case config.MatchTypeEnhancedCode:
+				return reply[2]
+			default:
+				return reply[3]
+			}
+		}
+		if cfg, m := findSubmatch(e.config.MilterReplies, func(cfg config.ReplyMatchConfig) []int {
+			return cfg.Regexp.FindStringSubmatchIndex(match(cfg.Match))
+		}); m != nil {
+			text := string(cfg.Regexp.ExpandString(nil, cfg.Text, match(cfg.Match), m))
+			e.milterReplies.WithLabelValues(r.Program, r.Subprogram, matches[1], parseStage(matches[2]), reply[1], reply[2], text).Inc()
+		}
+	} else if matches := reMilterError.FindStringSubmatch(r.Text); matches != nil {
+		milter, text := matches[1], matches[2]
+		if milter == "" {
+			milter, text = matches[3], "connect"
+		}
+		e.milterErrors.WithLabelValues(r.Program, r.Subprogram, milter, milterError(text)).Inc()
+	} else {
+		return false
+	}
+	return true
+}
+
+// processConnectionFailure counts failures to connect to or greet a remote server
+// in text, either the record text or the reason of a delivery status.
+// rcptDomain is the recipient domain if known.
+// It returns false if text is not a connection failure.
+func (e *Exporter) processConnectionFailure(r record, text, rcptDomain string) bool {
+	var host, addr string
+	if matches := reConnectFailed.FindStringSubmatch(text); matches != nil {
+		host, addr, text = matches[1], matches[2], matches[3]
+	} else if matches := reConversationFailed.FindStringSubmatch(text); matches != nil {
+		host, addr = matches[1], matches[2]
+	} else if matches := reHostNotFound.FindStringSubmatch(text); matches != nil {
+		host = cmp.Or(matches[1], matches[3])
+	} else {
+		return false
+	}
+	family := "unknown"
+	if addr != "" {
+		family = "ipv4"
+		if strings.Contains(addr, ":") {
+			family = "ipv6"
+		}
+	}
+	e.connectionFailures.WithLabelValues(r.Program, r.Subprogram, classifyDelayReason(text), family, e.failureDomain(rcptDomain, host)).Inc()
+	return true
+}
+
+// failureDomain returns the first configured domain one of names belongs to,
+// "other" for other names or an empty string if no domains are configured.
+func (e *Exporter) failureDomain(names ...string) string {
+	domains := e.config.ConnectionFailures.Domains
+	if len(domains) == 0 {
+		return ""
+	}
+	for _, name := range names {
+		name = strings.ToLower(strings.TrimSuffix(name, "."))
+		if name == "" {
+			continue
+		}
+		for _, domain := range domains {
+			if name == domain || strings.HasSuffix(name, "."+domain) {
+				return domain
+			}
+		}
+	}
+	return otherLabelValue
+}
+
+// login counts a SASL authentication attempt, by username if enabled.
+func (e *Exporter) login(r record, method, result string) {
+	e.saslLogins.WithLabelValues(r.Program, r.Subprogram, method, result).Inc()
+	if e.usernames == nil {
+		return
+	}
+	if matches := reSASLUsername.FindStringSubmatch(r.Text); matches != nil {
+		e.saslUserLogins.WithLabelValues(r.Program, r.Subprogram, e.usernames.value(matches[1]), result).Inc()
+	}
+}
+
+// smtpdSession returns the key of the smtpd session a record belongs to.
+// An smtpd process handles one session at a time.
+func smtpdSession(r record) string {
+	return r.Program + "/" + r.Subprogram + "[" + strconv.FormatInt(r.PID, 10) + "]"
+}
+
+// processTLS counts TLS connections and handshake failures.
+// It returns false if the record is not TLS related.
+func (e *Exporter) processTLS(r record) bool {
+	if matches := reTLSConnection.FindStringSubmatch(r.Text); matches != nil {
+		direction := "incoming"
+		if matches[2] == "to" {
+			direction = "outgoing"
+		}
+		e.tlsConnections.WithLabelValues(r.Program, r.Subprogram, direction, strings.ToLower(matches[1]), matches[3], matches[4]).Inc()
+	} else if reTLSHandshakeFailed.MatchString(r.Text) {
+		e.tlsHandshakeFailures.WithLabelValues(r.Program, r.Subprogram).Inc()
+	} else {
+		return false
+	}
+	return true
+}
+
+// rejectReason returns a reason class of a NOQUEUE reject from reNoqueueReject matches,
+// trying the configured reasons first. rest is the remainder of the record after matches.
+func (e *Exporter) rejectReason(matches []string, rest string) string {
+	text := matches[5]
+	// The reply text may have more parts, such as the DNS blocklist name, before the envelope.
+	if i := envelopeIndex(rest); i > 0 {
+		text += "; " + strings.TrimSuffix(rest[:i], "; ")
+	}
+	match := func(typ config.MatchType) string {
+		switch typ {
+		case config.MatchTypeCode:
+			return matches[2]
+		case config.MatchTypeEnhancedCode:
+			return matches[3]
+		default:
+			return text
+		}
+	}
+	if cfg, m := findSubmatch(e.config.NoqueueRejectReasons, func(cfg config.ReplyMatchConfig) []int {
+		return cfg.Regexp.FindStringSubmatchIndex(match(cfg.Match))
+	}); m != nil {
+		return string(cfg.Regexp.ExpandString(nil, cfg.Text, match(cfg.Match), m))
+	}
+	return classifyRejectReason(text)
+}
+
+// envelopeIndex returns the index of the from= or proto= envelope information in s or -1.
+func envelopeIndex(s string) int {
+	i := -1
+	for _, prefix := range []string{"from=<", "proto="} {
+		if j := strings.Index(s, prefix); j != -1 && (i == -1 || j < i) {
+			i = j
+		}
+	}
+	return i
+}
+
+// observeStageDelays observes the delivery stage delays from the delays= field.
+func (e *Exporter) observeStageDelays(instance, subprogram, text string) {
+	matches := reDelays.FindStringSubmatch(text)
+	if matches == nil {
+		return
+	}
+	for i, stage := range delayStages {
+		f, _ := strconv.ParseFloat(matches[i+1], 64)
+		e.stageDelays.WithLabelValues(instance, subprogram, stage).Observe(f)
+	}
+}
+
+// accept starts tracking a message accepted by Postfix and returns it.
+func (e *Exporter) accept(instance, id, origin string, t time.Time) *message {
+	m := e.tracker.get(instance, id, true)
+	if !m.accepted {
+		m.accepted = true
+		m.start = t
+	}
+	if m.origin == "" {
+		m.origin = origin
+	}
+	return m
+}
+
+// deliver records a delivery status of a tracked message.
+func (e *Exporter) deliver(instance, text, status string) {
+	if matches := reQueueID.FindStringSubmatch(text); matches != nil {
+		if m := e.tracker.get(instance, matches[1], false); m != nil {
+			m.deliver(status)
+			if m.notification != "" {
+				e.notificationDeliveries.WithLabelValues(instance, m.notification, status).Inc()
+			}
+		}
+	}
+}
+
+// setMasterVersion replaces the Postfix version of an instance.
+func (e *Exporter) setMasterVersion(instance, version string) {
+	e.masterInfo.DeletePartialMatch(prometheus.Labels{"postfix_instance": instance})
+	e.masterInfo.WithLabelValues(instance, version).Set(1)
+}
+
+// remove stops tracking a message removed from the queue.
+func (e *Exporter) remove(instance, id string, t time.Time) {
+	m := e.tracker.get(instance, id, false)
+	if m == nil {
+		return
+	}
+	e.tracker.remove(m)
+	if !m.accepted || !m.active {
+		// The message was accepted before the exporter was started.
+		return
+	}
+	outcome := cmp.Or(m.outcome, "unknown")
+	e.messageSizes.WithLabelValues(instance, outcome).Observe(m.size)
+	e.messageRecipients.WithLabelValues(instance, outcome).Observe(m.nrcpt)
+	e.messageQueueTimes.WithLabelValues(instance, outcome).Observe(t.Sub(m.start).Seconds())
+}
+
+// notify counts a notification about the original message sent as another message,
+// linking them if the original message is tracked.
+func (e *Exporter) notify(instance, id, notificationID, recipient, typ string) {
+	origin := "unknown"
+	if m := e.tracker.get(instance, id, false); m != nil && m.origin != "" {
+		origin = m.origin
+	}
+	e.notifications.WithLabelValues(instance, typ, recipient, origin).Inc()
+	m := e.tracker.get(instance, notificationID, true)
+	m.notification = typ
+	m.origin = "bounce"
+}
+
 // New returns an initialized exporter.
-func New(collector Collector, instance string, cfg *config.Config, logger *slog.Logger) (*Exporter, error) {
-	quantiles := map[float64]float64{0.5: 0.05, 0.9: 0.01, 0.99: 0.001}
+func New(collector Collector, instance *regexp.Regexp, cfg *config.Config, logger *slog.Logger) (*Exporter, error) {
+	cfg = cmp.Or(cfg, &config.Config{})
 	e := &Exporter{
 		ch:        make(chan result),
+		flush:     make(chan struct{}),
+		reload:    make(chan reloadRequest),
 		done:      make(chan struct{}),
 		collector: collector,
 		instance:  instance,
 		logger:    logger,
-		config:    cmp.Or(cfg, &config.Config{}),
+		config:    cfg,
+		sasl:      make(map[string]bool),
 
 		errors: prometheus.NewCounter(prometheus.CounterOpts{
 			Namespace: namespace,
@@ -361,83 +974,250 @@ func New(collector Collector, instance string, cfg *config.Config, logger *slog.
 			Name:      "foreign_total",
 			Help:      "Total number of foreign log records.",
 		}),
-		unsupported: prometheus.NewCounter(prometheus.CounterOpts{
+		unsupported: prometheus.NewCounterVec(prometheus.CounterOpts{
 			Namespace: namespace,
 			Name:      "unsupported_total",
 			Help:      "Total number of unsupported log records.",
-		}),
+		}, []string{"postfix_instance"}),
 		postscreen: prometheus.NewCounterVec(prometheus.CounterOpts{
 			Namespace: namespace,
 			Name:      "postscreen_actions_total",
 			Help:      "Total number of times postscreen events were collected.",
-		}, []string{"action"}),
+		}, []string{"postfix_instance", "action"}),
+		dnsblRanks: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "postscreen_dnsbl_rank",
+			Help:      "Combined DNSBL rank of clients checked by postscreen.",
+			Buckets:   []float64{0, 1, 2, 3, 4, 5, 6, 8, 10, 15, 20},
+		}, []string{"postfix_instance"}),
+		dnsblListings: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "dnsblog_listings_total",
+			Help:      "Total number of times clients were found listed by DNSBL domains.",
+		}, []string{"postfix_instance", "domain", "code"}),
 		connects: prometheus.NewCounterVec(prometheus.CounterOpts{
 			Namespace: namespace,
 			Name:      "connects_total",
 			Help:      "Total number of times connect events were collected.",
-		}, []string{"subprogram"}),
+		}, []string{"postfix_instance", "subprogram"}),
 		disconnects: prometheus.NewCounterVec(prometheus.CounterOpts{
 			Namespace: namespace,
 			Name:      "disconnects_total",
 			Help:      "Total number of times disconnect events were collected.",
-		}, []string{"subprogram"}),
+		}, []string{"postfix_instance", "subprogram"}),
+		commands: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "smtpd_commands_total",
+			Help:      "Total number of SMTP commands issued by clients by result.",
+		}, []string{"postfix_instance", "subprogram", "command", "result"}),
+		sessionCommands: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "smtpd_session_commands",
+			Help:      "Number of SMTP commands issued by clients per session.",
+			Buckets:   []float64{1, 2, 3, 5, 10, 20, 50, 100, 200, 500},
+		}, []string{"postfix_instance", "subprogram"}),
+		acceptedMessages: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "smtpd_messages_accepted_total",
+			Help:      "Total number of messages accepted from clients.",
+		}, []string{"postfix_instance", "subprogram"}),
+		acceptedRecipients: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "smtpd_recipients_accepted_total",
+			Help:      "Total number of recipients of messages accepted from clients.",
+		}, []string{"postfix_instance", "subprogram"}),
 		lostConnections: prometheus.NewCounterVec(prometheus.CounterOpts{
 			Namespace: namespace,
 			Name:      "lost_connections_total",
 			Help:      "Total number of times lost connection events were collected.",
-		}, []string{"subprogram"}),
+		}, []string{"postfix_instance", "subprogram", "stage"}),
+		timeouts: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "timeouts_total",
+			Help:      "Total number of times timeout events were collected.",
+		}, []string{"postfix_instance", "subprogram", "stage"}),
+		tooManyErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "too_many_errors_total",
+			Help:      "Total number of times too many errors events were collected.",
+		}, []string{"postfix_instance", "subprogram", "stage"}),
 		hostnameNotResolved: prometheus.NewCounterVec(prometheus.CounterOpts{
 			Namespace: namespace,
 			Name:      "not_resolved_hostnames_total",
 			Help:      "Total number of times not resolved hostname events were collected.",
-		}, []string{"subprogram"}),
+		}, []string{"postfix_instance", "subprogram"}),
 		statuses: prometheus.NewCounterVec(prometheus.CounterOpts{
 			Namespace: namespace,
 			Name:      "statuses_total",
 			Help:      "Total number of times server message status change events were collected.",
-		}, []string{"subprogram", "status"}),
-		delays: prometheus.NewSummaryVec(prometheus.SummaryOpts{
-			Namespace:  namespace,
-			Name:       "delay_seconds",
-			Help:       "Delay in seconds for a server to process a message.",
-			Objectives: quantiles,
-		}, []string{"subprogram", "status"}),
+		}, []string{"postfix_instance", "subprogram", "transport", "status"}),
+		delays: newDelayVec(cfg.DelayMetrics, true, prometheus.Opts{
+			Namespace: namespace,
+			Name:      "delay_seconds",
+			Help:      "Delay in seconds for a server to process a message.",
+		}, []string{"postfix_instance", "subprogram", "transport", "status"}),
+		stageDelays: newDelayVec(cfg.DelayMetrics, false, prometheus.Opts{
+			Namespace: namespace,
+			Name:      "delivery_stage_delay_seconds",
+			Help:      "Delay in seconds for a message to pass a delivery stage.",
+		}, []string{"postfix_instance", "subprogram", "stage"}),
 		statusReplies: prometheus.NewCounterVec(prometheus.CounterOpts{
 			Namespace: namespace,
 			Name:      "status_replies_total",
 			Help:      "Total number of times server message status change event replies were collected.",
-		}, []string{"subprogram", "status", "code", "enhanced_code", "text"}),
+		}, []string{"postfix_instance", "subprogram", "transport", "status", "code", "enhanced_code", "text"}),
+		pipeDeliveries: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "pipe_deliveries_total",
+			Help:      "Total number of times pipe delivery agent message status change events were collected.",
+		}, []string{"postfix_instance", "subprogram", "command", "status"}),
 		smtpReplies: prometheus.NewCounterVec(prometheus.CounterOpts{
 			Namespace: namespace,
 			Name:      "smtp_replies_total",
 			Help:      "Total number of times SMTP server replies were collected.",
-		}, []string{"code", "enhanced_code", "text"}),
+		}, []string{"postfix_instance", "code", "enhanced_code", "text"}),
 		milter: prometheus.NewCounterVec(prometheus.CounterOpts{
 			Namespace: namespace,
 			Name:      "milter_actions_total",
 			Help:      "Total number of times milter events were collected.",
-		}, []string{"subprogram", "action"}),
+		}, []string{"postfix_instance", "subprogram", "action"}),
+		milterReplies: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "milter_replies_total",
+			Help:      "Total number of times milter event replies were collected.",
+		}, []string{"postfix_instance", "subprogram", "action", "stage", "code", "enhanced_code", "text"}),
+		milterErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "milter_errors_total",
+			Help:      "Total number of times milter communication error events were collected.",
+		}, []string{"postfix_instance", "subprogram", "milter", "error"}),
 		loginFailed: prometheus.NewCounterVec(prometheus.CounterOpts{
 			Namespace: namespace,
 			Name:      "login_failures_total",
 			Help:      "Total number of times login failure events were collected.",
-		}, []string{"subprogram", "method"}),
+		}, []string{"postfix_instance", "subprogram", "method"}),
+		saslLogins: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "sasl_logins_total",
+			Help:      "Total number of times SASL authentication events were collected.",
+		}, []string{"postfix_instance", "subprogram", "method", "result"}),
+		saslUserLogins: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "sasl_username_logins_total",
+			Help:      "Total number of times SASL authentication events were collected by username.",
+		}, []string{"postfix_instance", "subprogram", "username", "result"}),
 		qmgrStatuses: prometheus.NewCounterVec(prometheus.CounterOpts{
 			Namespace: namespace,
 			Name:      "qmgr_statuses_total",
 			Help:      "Total number of times Postfix queue manager message status change events were collected.",
-		}, []string{"status"}),
+		}, []string{"postfix_instance", "status"}),
 		logs: prometheus.NewCounterVec(prometheus.CounterOpts{
 			Namespace: namespace,
 			Name:      "logs_total",
 			Help:      "Total number of log records processed.",
-		}, []string{"subprogram", "severity"}),
+		}, []string{"postfix_instance", "subprogram", "severity"}),
 		noqueueRejectReplies: prometheus.NewCounterVec(prometheus.CounterOpts{
 			Namespace: namespace,
 			Name:      "noqueue_reject_replies_total",
 			Help:      "Total number of times NOQUEUE: reject event replies were collected.",
-		}, []string{"subprogram", "command", "code", "enhanced_code", "text"}),
+		}, []string{"postfix_instance", "subprogram", "command", "code", "enhanced_code", "text"}),
+		noqueueRejects: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "noqueue_rejects_total",
+			Help:      "Total number of times NOQUEUE: reject events were collected by reason.",
+		}, []string{"postfix_instance", "subprogram", "command", "code", "reason"}),
+		tlsConnections: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "tls_connections_total",
+			Help:      "Total number of times TLS connection established events were collected.",
+		}, []string{"postfix_instance", "subprogram", "direction", "trust", "protocol", "cipher"}),
+		tlsHandshakeFailures: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "tls_handshake_failures_total",
+			Help:      "Total number of times TLS handshake failure events were collected.",
+		}, []string{"postfix_instance", "subprogram"}),
+		messageSizes: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "message_size_bytes",
+			Help:      "Size in bytes of messages removed from the queue.",
+			Buckets:   prometheus.ExponentialBuckets(1024, 4, 10),
+		}, []string{"postfix_instance", "outcome"}),
+		messageRecipients: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "message_recipients",
+			Help:      "Number of recipients of messages removed from the queue.",
+			Buckets:   []float64{1, 2, 5, 10, 20, 50, 100, 200, 500, 1000},
+		}, []string{"postfix_instance", "outcome"}),
+		messageQueueTimes: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "message_queue_time_seconds",
+			Help:      "Time in seconds messages spent from being accepted to being removed from the queue.",
+			Buckets:   []float64{0.1, 0.5, 1, 5, 10, 30, 60, 300, 600, 1800, 3600, 21600, 86400, 432000},
+		}, []string{"postfix_instance", "outcome"}),
+		trackerEvictions: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "message_tracker_evictions_total",
+			Help:      "Total number of tracked messages evicted before being removed from the queue.",
+		}),
+		notifications: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "bounce_notifications_total",
+			Help:      "Total number of times bounce notification events were collected.",
+		}, []string{"postfix_instance", "type", "recipient", "origin"}),
+		notificationDeliveries: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "bounce_notification_statuses_total",
+			Help:      "Total number of times bounce notification message status change events were collected.",
+		}, []string{"postfix_instance", "type", "status"}),
+		masterEvents: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "master_events_total",
+			Help:      "Total number of times master daemon start, reload and stop events were collected.",
+		}, []string{"postfix_instance", "event"}),
+		masterInfo: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "master_info",
+			Help:      "Postfix version reported by the master daemon on start or reload.",
+		}, []string{"postfix_instance", "version"}),
+		processExits: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "process_exits_total",
+			Help:      "Total number of times process exit events were collected.",
+		}, []string{"postfix_instance", "service", "status"}),
+		processLimits: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "process_limit_reached_total",
+			Help:      "Total number of times service process limit reached events were collected.",
+		}, []string{"postfix_instance", "service"}),
+		connectionFailures: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "smtp_connection_failures_total",
+			Help:      "Total number of times remote server connection failure events were collected.",
+		}, []string{"postfix_instance", "subprogram", "reason", "ip_family", "domain"}),
+		domainStatuses: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "domain_statuses_total",
+			Help:      "Total number of times server message status change events were collected by relay and recipient domain.",
+		}, []string{"postfix_instance", "transport", "status", "relay_domain", "recipient_domain"}),
 	}
+	e.tracker = newTracker(
+		cmp.Or(e.config.MessageTracking.MaxMessages, 10000),
+		cmp.Or(e.config.MessageTracking.TTL, 5*24*time.Hour),
+		e.trackerEvictions.Inc,
+	)
+	// Custom metrics must not conflict with built-in metrics as registering
+	// the exporter would fail otherwise.
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(describer(e.describeBuiltin))
+	for _, cfg := range e.config.CustomMetrics {
+		m := newCustomMetric(cfg)
+		if err := reg.Register(m.collector); err != nil {
+			return nil, errors.New("invalid custom metric " + strconv.Quote(cfg.Name) + ": " + err.Error())
+		}
+		e.custom = append(e.custom, m)
+	}
+	e.usernames = newUsernameLimiter(e.config.SASLUsernames)
+	e.relays, e.domains = newDeliveryDomainLimiters(e.config.DeliveryDomains)
 	if err := e.collector.Collect(e.ch); err != nil {
 		return nil, err
 	}
@@ -448,6 +1228,9 @@ func New(collector Collector, instance string, cfg *config.Config, logger *slog.
 			select {
 			case res := <-e.ch:
 				e.process(res.rec, res.err)
+			case <-e.flush:
+			case req := <-e.reload:
+				req.err <- e.applyConfig(req.cfg)
 			case <-e.done:
 				return
 			}
@@ -456,6 +1239,105 @@ func New(collector Collector, instance string, cfg *config.Config, logger *slog.
 	return e, nil
 }
 
+// newDelayVec returns a summary or a histogram depending on the config.
+// If summary is false, a histogram is returned instead of a summary:
+// only the delay_seconds metric may be a summary for backward compatibility.
+func newDelayVec(cfg config.DelayMetricsConfig, summary bool, opts prometheus.Opts, labels []string) prometheus.ObserverVec {
+	if cfg.Type == config.DelayMetricTypeSummary && summary {
+		return prometheus.NewSummaryVec(prometheus.SummaryOpts{
+			Namespace:  opts.Namespace,
+			Name:       opts.Name,
+			Help:       opts.Help,
+			Objectives: map[float64]float64{0.5: 0.05, 0.9: 0.01, 0.99: 0.001},
+		}, labels)
+	}
+	hOpts := prometheus.HistogramOpts{
+		Namespace: opts.Namespace,
+		Name:      opts.Name,
+		Help:      opts.Help,
+		Buckets:   cfg.Buckets,
+	}
+	if cfg.Type == config.DelayMetricTypeNativeHistogram {
+		hOpts.NativeHistogramBucketFactor = cmp.Or(cfg.NativeHistogramBucketFactor, 1.1)
+		hOpts.NativeHistogramMaxBucketNumber = cmp.Or(cfg.NativeHistogramMaxBucketNumber, 160)
+		hOpts.NativeHistogramMinResetDuration = cmp.Or(cfg.NativeHistogramMinResetDuration, time.Hour)
+	} else if len(hOpts.Buckets) == 0 {
+		hOpts.Buckets = delayBuckets
+	}
+	return prometheus.NewHistogramVec(hOpts, labels)
+}
+
+// observeCommands observes the per-command statistics from a disconnect
+// log record, such as "ehlo=1 mail=1 rcpt=0/1 quit=1 commands=2/3".
+func (e *Exporter) observeCommands(r record, stats string) {
+	for _, field := range strings.Fields(stats) {
+		matches := reCommandStats.FindStringSubmatch(field)
+		if matches == nil {
+			continue
+		}
+		succeeded, _ := strconv.ParseFloat(matches[2], 64)
+		total := succeeded
+		if matches[3] != "" {
+			total, _ = strconv.ParseFloat(matches[3], 64)
+		}
+		if matches[1] == "commands" {
+			e.sessionCommands.WithLabelValues(r.Program, r.Subprogram).Observe(total)
+			continue
+		}
+		e.commands.WithLabelValues(r.Program, r.Subprogram, strings.ToUpper(matches[1]), "succeeded").Add(succeeded)
+		if total > succeeded {
+			e.commands.WithLabelValues(r.Program, r.Subprogram, strings.ToUpper(matches[1]), "failed").Add(total - succeeded)
+		}
+	}
+}
+
+// parseStage returns an SMTP command name from a session stage,
+// such as DATA from "DATA (0 bytes)", or "other" for an unknown one.
+func parseStage(s string) string {
+	if stage := reStage.FindString(s); stage != "" {
+		return stage
+	}
+	return "other"
+}
+
+// deliveryAgents are the delivery agents reporting message status changes.
+var deliveryAgents = []string{"smtp", "lmtp", "local", "virtual", "pipe", "error", "discard", "retry"}
+
+// deliveryAgent returns a delivery agent name from a subprogram,
+// such as smtp from "relay/smtp", or an empty string for other subprograms.
+func deliveryAgent(subprogram string) string {
+	if i := strings.LastIndexByte(subprogram, '/'); i != -1 {
+		subprogram = subprogram[i+1:]
+	}
+	if slices.Contains(deliveryAgents, subprogram) {
+		return subprogram
+	}
+	return ""
+}
+
+// milterError returns a milter communication error class: connect, read,
+// write, timeout or "other".
+func milterError(s string) string {
+	switch {
+	case s == "connect":
+		return "connect"
+	case strings.Contains(s, "timeout") || strings.Contains(s, "timed out"):
+		return "timeout"
+	case strings.HasPrefix(s, "can't read") || strings.Contains(s, "read error"):
+		return "read"
+	case strings.HasPrefix(s, "can't send") || strings.Contains(s, "write error"):
+		return "write"
+	}
+	return otherLabelValue
+}
+
+// notificationTypes map bounce notification kinds to types.
+var notificationTypes = map[string]string{
+	"non-delivery":    "non_delivery",
+	"delay":           "delay",
+	"delivery status": "success",
+}
+
 type hostReply struct {
 	Code         string
 	EnhancedCode string
@@ -0,0 +1,121 @@
+package exporter
+
+import (
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/prometheus/common/promslog"
+	"github.com/sergeymakinen/postfix_exporter/v2/config"
+)
+
+// lines collects Postfix logs from a channel.
+type lines struct {
+	lines chan string
+	done  chan struct{}
+	wg    sync.WaitGroup
+}
+
+func (l *lines) Collect(ch chan<- result) error {
+	l.done = make(chan struct{})
+	l.wg.Add(1)
+	go func() {
+		defer l.wg.Done()
+		for s := range l.lines {
+			var res result
+			res.rec, res.err = parseRecord(s)
+			select {
+			case ch <- res:
+			case <-l.done:
+				return
+			}
+		}
+	}()
+	return nil
+}
+
+// Wait stops accepting lines and waits until every line is handed off.
+func (l *lines) Wait() {
+	close(l.lines)
+	l.wg.Wait()
+}
+
+func (l *lines) Close() error {
+	close(l.done)
+	return nil
+}
+
+func TestExporter_Reload(t *testing.T) {
+	collector := &lines{lines: make(chan string)}
+	exporter, err := New(collector, testInstance, nil, promslog.NewNopLogger())
+	if err != nil {
+		t.Fatalf("New() = _, %v; want nil", err)
+	}
+	defer exporter.Close()
+	const line = "Jan 1 00:00:00 hostname postfix/smtp[12345]: 123456789AB: host example.com[123.45.67.89] said: 123 1.2.3 Greylisting in action, please come back later (in reply to RCPT TO command)"
+	collector.lines <- line
+	cfg, err := config.Load("testdata/postfix.yml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = exporter.Reload(cfg); err == nil {
+		t.Error("Reload() = nil; want error")
+	}
+	cfg.CustomMetrics = nil
+	if err = exporter.Reload(cfg); err != nil {
+		t.Errorf("Reload() = %v; want nil", err)
+	}
+	collector.lines <- line
+	exporter.Wait()
+	const metrics = `# HELP postfix_smtp_replies_total Total number of times SMTP server replies were collected.
+# TYPE postfix_smtp_replies_total counter
+postfix_smtp_replies_total{code="123",enhanced_code="1.2.3",postfix_instance="postfix",text="graylist"} 1
+`
+	if err := testutil.CollectAndCompare(exporter, strings.NewReader(metrics), "postfix_smtp_replies_total"); err != nil {
+		t.Errorf("testutil.CollectAndCompare() = %v; want nil", err)
+	}
+}
+
+func TestNew_CustomMetricConflict(t *testing.T) {
+	for _, name := range []string{"postfix_logs_total", "postfix_statuses_total"} {
+		cfg := &config.Config{
+			CustomMetrics: []config.CustomMetricConfig{
+				{
+					Name:   name,
+					Help:   "Conflicting custom metric.",
+					Regexp: &config.Regexp{Regexp: regexp.MustCompile(`foo`)},
+				},
+			},
+		}
+		if _, err := New(&lines{lines: make(chan string)}, testInstance, cfg, promslog.NewNopLogger()); err == nil {
+			t.Errorf("New() with custom metric %q = _, nil; want error", name)
+		}
+	}
+}
+
+func TestExporter_SASLLoginsPerSession(t *testing.T) {
+	collector := &lines{lines: make(chan string)}
+	exporter, err := New(collector, testInstance, nil, promslog.NewNopLogger())
+	if err != nil {
+		t.Fatalf("New() = _, %v; want nil", err)
+	}
+	defer exporter.Close()
+	for _, s := range []string{
+		"Jan 1 00:00:00 hostname postfix/submission/smtpd[12345]: 123456789AB: client=example.com[123.45.67.89], sasl_method=PLAIN, sasl_username=user@example.com",
+		"Jan 1 00:00:00 hostname postfix/submission/smtpd[12345]: 123456789AC: client=example.com[123.45.67.89], sasl_method=PLAIN, sasl_username=user@example.com",
+		"Jan 1 00:00:00 hostname postfix/submission/smtpd[12345]: disconnect from example.com[123.45.67.89] ehlo=1 auth=1 mail=2 rcpt=2 data=2 quit=1 commands=9",
+		"Jan 1 00:00:00 hostname postfix/submission/smtpd[12345]: 123456789AD: client=example.com[123.45.67.89], sasl_method=PLAIN, sasl_username=user@example.com",
+	} {
+		collector.lines <- s
+	}
+	exporter.Wait()
+	const metrics = `# HELP postfix_sasl_logins_total Total number of times SASL authentication events were collected.
+# TYPE postfix_sasl_logins_total counter
+postfix_sasl_logins_total{method="PLAIN",postfix_instance="postfix",result="succeeded",subprogram="submission/smtpd"} 2
+`
+	if err := testutil.CollectAndCompare(exporter, strings.NewReader(metrics), "postfix_sasl_logins_total"); err != nil {
+		t.Errorf("testutil.CollectAndCompare() = %v; want nil", err)
+	}
+}
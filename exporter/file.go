@@ -2,24 +2,36 @@ package exporter
 
 import (
 	"bufio"
+	"encoding/json"
+	"errors"
 	"io"
 	"os"
+	"path/filepath"
+	"strings"
 	"sync"
+	"time"
 
 	"github.com/nxadm/tail"
 )
 
 // File collects Postfix logs from a file.
 type File struct {
-	Path string
-	Test bool
+	Path         string
+	PositionFile string
+	Test         bool
 
 	tail   *tail.Tail
+	pos    filePosition
 	closed bool
 	done   chan struct{}
 	wg     sync.WaitGroup
 }
 
+type filePosition struct {
+	Inode  uint64 `json:"inode"`
+	Offset int64  `json:"offset"`
+}
+
 func (f *File) Collect(ch chan<- result) error {
 	f.done = make(chan struct{})
 	if f.Test {
@@ -29,8 +41,45 @@ func (f *File) Collect(ch chan<- result) error {
 }
 
 func (f *File) start(ch chan<- result) error {
+	fi, err := os.Stat(f.Path)
+	if err != nil {
+		return err
+	}
+	// Seek to the current end of the file synchronously so that lines
+	// written after Collect returns are not skipped.
+	loc := &tail.SeekInfo{Offset: fi.Size()}
+	var rotated string
+	cur := filePosition{
+		Inode:  fileInode(fi),
+		Offset: fi.Size(),
+	}
+	if f.PositionFile != "" {
+		pos, err := readFilePosition(f.PositionFile)
+		if err != nil {
+			return err
+		}
+		if pos != nil {
+			cur.Offset = 0
+			if pos.Inode != cur.Inode {
+				// The file was rotated: finish reading the old one
+				// and then read the new one from the beginning.
+				rotated = findRotatedFile(f.Path, pos.Inode)
+				loc = &tail.SeekInfo{}
+			} else if pos.Offset <= fi.Size() {
+				loc = &tail.SeekInfo{Offset: pos.Offset}
+				cur.Offset = pos.Offset
+			} else {
+				// The file was truncated.
+				loc = &tail.SeekInfo{}
+			}
+		}
+		f.pos = cur
+		if rotated != "" {
+			f.pos = *pos
+		}
+	}
 	t, err := tail.TailFile(f.Path, tail.Config{
-		Location:  &tail.SeekInfo{Whence: io.SeekEnd},
+		Location:  loc,
 		ReOpen:    true,
 		MustExist: true,
 		Follow:    true,
@@ -43,6 +92,20 @@ func (f *File) start(ch chan<- result) error {
 	f.wg.Add(1)
 	go func() {
 		defer f.wg.Done()
+		var ticker <-chan time.Time
+		if f.PositionFile != "" {
+			defer f.savePosition()
+			t := time.NewTicker(time.Second)
+			defer t.Stop()
+			ticker = t.C
+		}
+		if rotated != "" {
+			if !f.readRotated(rotated, ch) {
+				return
+			}
+			f.pos = cur
+		}
+		saved := f.pos
 		for {
 			select {
 			case s := <-f.tail.Lines:
@@ -53,6 +116,18 @@ func (f *File) start(ch chan<- result) error {
 				case <-f.done:
 					return
 				}
+				if s.SeekInfo.Offset < f.pos.Offset {
+					// The file was reopened after being rotated or truncated.
+					if fi, err := os.Stat(f.Path); err == nil {
+						f.pos.Inode = fileInode(fi)
+					}
+				}
+				f.pos.Offset = s.SeekInfo.Offset
+			case <-ticker:
+				if f.pos != saved {
+					f.savePosition()
+					saved = f.pos
+				}
 			case <-f.done:
 				return
 			}
@@ -61,6 +136,41 @@ func (f *File) start(ch chan<- result) error {
 	return nil
 }
 
+// readRotated reads the rest of a rotated file starting from the saved offset.
+func (f *File) readRotated(name string, ch chan<- result) bool {
+	ff, err := os.Open(name)
+	if err != nil {
+		return true
+	}
+	defer ff.Close()
+	if _, err = ff.Seek(f.pos.Offset, io.SeekStart); err != nil {
+		return true
+	}
+	r := bufio.NewReader(ff)
+	for {
+		s, err := r.ReadString('\n')
+		if s != "" {
+			var res result
+			res.rec, res.err = parseRecord(strings.TrimSuffix(s, "\n"))
+			select {
+			case ch <- res:
+			case <-f.done:
+				return false
+			}
+			f.pos.Offset += int64(len(s))
+		}
+		if err != nil {
+			return true
+		}
+	}
+}
+
+func (f *File) savePosition() {
+	if b, err := json.Marshal(f.pos); err == nil {
+		writeStateFile(f.PositionFile, b)
+	}
+}
+
 func (f *File) read(ch chan<- result) error {
 	ff, err := os.Open(f.Path)
 	if err != nil {
@@ -98,6 +208,41 @@ func (f *File) Close() error {
 	if f.tail != nil {
 		defer f.tail.Cleanup()
 		err = f.tail.Stop()
+		f.wg.Wait()
 	}
 	return err
 }
+
+func readFilePosition(name string) (*filePosition, error) {
+	b, err := os.ReadFile(name)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.New("error reading position file: " + err.Error())
+	}
+	var pos filePosition
+	if err = json.Unmarshal(b, &pos); err != nil {
+		return nil, errors.New("error parsing position file: " + err.Error())
+	}
+	return &pos, nil
+}
+
+// findRotatedFile returns a file next to name with the given inode.
+func findRotatedFile(name string, inode uint64) string {
+	dir, base := filepath.Split(name)
+	entries, err := os.ReadDir(filepath.Clean(dir))
+	if err != nil {
+		return ""
+	}
+	for _, entry := range entries {
+		if entry.Name() == base || !strings.HasPrefix(entry.Name(), base) {
+			continue
+		}
+		fi, err := entry.Info()
+		if err == nil && fi.Mode().IsRegular() && fileInode(fi) == inode {
+			return filepath.Join(dir, entry.Name())
+		}
+	}
+	return ""
+}
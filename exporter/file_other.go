@@ -0,0 +1,9 @@
+//go:build !unix
+
+package exporter
+
+import "os"
+
+func fileInode(os.FileInfo) uint64 { return 0 }
+
+func unblockFIFO(string) {}
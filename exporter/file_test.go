@@ -3,8 +3,12 @@ package exporter
 import (
 	"bufio"
 	"bytes"
+	"encoding/json"
 	"io"
 	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
 	"testing"
 	"time"
 
@@ -13,22 +17,55 @@ import (
 	"github.com/sergeymakinen/postfix_exporter/v2/config"
 )
 
+var testInstance = regexp.MustCompile(`^(?:postfix|postfix-out)$`)
+
 var testMetrics = []string{
 	"postfix_unsupported_total",
 	"postfix_postscreen_actions_total",
+	"postfix_postscreen_dnsbl_rank",
+	"postfix_dnsblog_listings_total",
 	"postfix_connects_total",
 	"postfix_disconnects_total",
+	"postfix_smtpd_commands_total",
+	"postfix_smtpd_session_commands",
+	"postfix_smtpd_messages_accepted_total",
+	"postfix_smtpd_recipients_accepted_total",
 	"postfix_lost_connections_total",
+	"postfix_timeouts_total",
+	"postfix_too_many_errors_total",
 	"postfix_not_resolved_hostnames_total",
 	"postfix_statuses_total",
 	"postfix_delay_seconds",
+	"postfix_delivery_stage_delay_seconds",
 	"postfix_status_replies_total",
+	"postfix_pipe_deliveries_total",
+	"postfix_domain_statuses_total",
 	"postfix_smtp_replies_total",
+	"postfix_smtp_connection_failures_total",
 	"postfix_milter_actions_total",
+	"postfix_milter_replies_total",
+	"postfix_milter_errors_total",
 	"postfix_login_failures_total",
+	"postfix_sasl_logins_total",
+	"postfix_sasl_username_logins_total",
 	"postfix_qmgr_statuses_total",
 	"postfix_logs_total",
 	"postfix_noqueue_reject_replies_total",
+	"postfix_noqueue_rejects_total",
+	"postfix_tls_connections_total",
+	"postfix_tls_handshake_failures_total",
+	"postfix_message_size_bytes",
+	"postfix_message_recipients",
+	"postfix_message_queue_time_seconds",
+	"postfix_message_tracker_evictions_total",
+	"postfix_bounce_notifications_total",
+	"postfix_bounce_notification_statuses_total",
+	"postfix_master_events_total",
+	"postfix_master_info",
+	"postfix_process_exits_total",
+	"postfix_process_limit_reached_total",
+	"postfix_policyd_spf_results_total",
+	"postfix_anvil_max_connection_rate",
 }
 
 var tests = map[string]struct {
@@ -63,7 +100,7 @@ func TestExporter_File_Collect(t *testing.T) {
 					t.Fatal(err)
 				}
 			}
-			exporter, err := New(&File{Path: out.Name()}, "postfix", cfg, promslog.NewNopLogger())
+			exporter, err := New(&File{Path: out.Name()}, testInstance, cfg, promslog.NewNopLogger())
 			if err != nil {
 				t.Fatalf("New() = _, %v; want nil", err)
 			}
@@ -117,11 +154,11 @@ func TestExporter_File_Test(t *testing.T) {
 				Path: "testdata/mail.log",
 				Test: true,
 			}
-			exporter, err := New(collector, "postfix", cfg, promslog.NewNopLogger())
+			exporter, err := New(collector, testInstance, cfg, promslog.NewNopLogger())
 			if err != nil {
 				t.Fatalf("New() = _, %v; want nil", err)
 			}
-			collector.Wait()
+			exporter.Wait()
 			b, err := os.ReadFile(test.Metrics)
 			if err != nil {
 				t.Fatal(err)
@@ -132,3 +169,167 @@ func TestExporter_File_Test(t *testing.T) {
 		})
 	}
 }
+
+func TestExporter_File_Position(t *testing.T) {
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			var (
+				cfg *config.Config
+				err error
+			)
+			if test.Cfg != "" {
+				cfg, err = config.Load(test.Cfg)
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+			b, err := os.ReadFile("testdata/mail.log")
+			if err != nil {
+				t.Fatal(err)
+			}
+			half := bytes.IndexByte(b[len(b)/2:], '\n') + len(b)/2 + 1
+			dir := t.TempDir()
+			path := filepath.Join(dir, "mail.log")
+			positionPath := filepath.Join(dir, "position.json")
+			// The first half was written before the exporter was stopped,
+			// then the file was rotated and the rest was written to the new file.
+			if err = os.WriteFile(path, b[:half], 0o644); err != nil {
+				t.Fatal(err)
+			}
+			fi, err := os.Stat(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			pos, _ := json.Marshal(filePosition{Inode: fileInode(fi)})
+			if err = os.WriteFile(positionPath, pos, 0o644); err != nil {
+				t.Fatal(err)
+			}
+			if err = os.Rename(path, path+".1"); err != nil {
+				t.Fatal(err)
+			}
+			if err = os.WriteFile(path, b[half:], 0o644); err != nil {
+				t.Fatal(err)
+			}
+			exporter, err := New(&File{Path: path, PositionFile: positionPath}, testInstance, cfg, promslog.NewNopLogger())
+			if err != nil {
+				t.Fatalf("New() = _, %v; want nil", err)
+			}
+			time.Sleep(5 * time.Second)
+			if err = exporter.Close(); err != nil {
+				t.Fatalf("Close() = %v; want nil", err)
+			}
+			metrics, err := os.ReadFile(test.Metrics)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := testutil.CollectAndCompare(exporter, bytes.NewReader(metrics), testMetrics...); err != nil {
+				t.Errorf("testutil.CollectAndCompare() = %v; want nil", err)
+			}
+			if fi, err = os.Stat(path); err != nil {
+				t.Fatal(err)
+			}
+			got, err := readFilePosition(positionPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := (filePosition{Inode: fileInode(fi), Offset: fi.Size()}); *got != want {
+				t.Errorf("readFilePosition() = %+v, _; want %+v", *got, want)
+			}
+		})
+	}
+}
+
+func TestExporter_File_DelayMetrics(t *testing.T) {
+	cfg := &config.Config{
+		DelayMetrics: config.DelayMetricsConfig{
+			Type:    config.DelayMetricTypeHistogram,
+			Buckets: []float64{1, 5},
+		},
+	}
+	collector := &File{
+		Path: "testdata/mail.log",
+		Test: true,
+	}
+	exporter, err := New(collector, testInstance, cfg, promslog.NewNopLogger())
+	if err != nil {
+		t.Fatalf("New() = _, %v; want nil", err)
+	}
+	exporter.Wait()
+	const metrics = `
+# HELP postfix_delay_seconds Delay in seconds for a server to process a message.
+# TYPE postfix_delay_seconds histogram
+postfix_delay_seconds_bucket{postfix_instance="postfix",status="bounced",subprogram="error",transport="error",le="1"} 1
+postfix_delay_seconds_bucket{postfix_instance="postfix",status="bounced",subprogram="error",transport="error",le="5"} 1
+postfix_delay_seconds_bucket{postfix_instance="postfix",status="bounced",subprogram="error",transport="error",le="+Inf"} 1
+postfix_delay_seconds_sum{postfix_instance="postfix",status="bounced",subprogram="error",transport="error"} 0.1
+postfix_delay_seconds_count{postfix_instance="postfix",status="bounced",subprogram="error",transport="error"} 1
+postfix_delay_seconds_bucket{postfix_instance="postfix",status="bounced",subprogram="lmtp",transport="lmtp",le="1"} 0
+postfix_delay_seconds_bucket{postfix_instance="postfix",status="bounced",subprogram="lmtp",transport="lmtp",le="5"} 2
+postfix_delay_seconds_bucket{postfix_instance="postfix",status="bounced",subprogram="lmtp",transport="lmtp",le="+Inf"} 2
+postfix_delay_seconds_sum{postfix_instance="postfix",status="bounced",subprogram="lmtp",transport="lmtp"} 4.23
+postfix_delay_seconds_count{postfix_instance="postfix",status="bounced",subprogram="lmtp",transport="lmtp"} 2
+postfix_delay_seconds_bucket{postfix_instance="postfix",status="bounced",subprogram="smtp",transport="smtp",le="1"} 0
+postfix_delay_seconds_bucket{postfix_instance="postfix",status="bounced",subprogram="smtp",transport="smtp",le="5"} 1
+postfix_delay_seconds_bucket{postfix_instance="postfix",status="bounced",subprogram="smtp",transport="smtp",le="+Inf"} 1
+postfix_delay_seconds_sum{postfix_instance="postfix",status="bounced",subprogram="smtp",transport="smtp"} 1.23
+postfix_delay_seconds_count{postfix_instance="postfix",status="bounced",subprogram="smtp",transport="smtp"} 1
+postfix_delay_seconds_bucket{postfix_instance="postfix",status="deferred",subprogram="pipe",transport="pipe",le="1"} 1
+postfix_delay_seconds_bucket{postfix_instance="postfix",status="deferred",subprogram="pipe",transport="pipe",le="5"} 1
+postfix_delay_seconds_bucket{postfix_instance="postfix",status="deferred",subprogram="pipe",transport="pipe",le="+Inf"} 1
+postfix_delay_seconds_sum{postfix_instance="postfix",status="deferred",subprogram="pipe",transport="pipe"} 0.2
+postfix_delay_seconds_count{postfix_instance="postfix",status="deferred",subprogram="pipe",transport="pipe"} 1
+postfix_delay_seconds_bucket{postfix_instance="postfix",status="deferred",subprogram="retry",transport="retry",le="1"} 0
+postfix_delay_seconds_bucket{postfix_instance="postfix",status="deferred",subprogram="retry",transport="retry",le="5"} 0
+postfix_delay_seconds_bucket{postfix_instance="postfix",status="deferred",subprogram="retry",transport="retry",le="+Inf"} 1
+postfix_delay_seconds_sum{postfix_instance="postfix",status="deferred",subprogram="retry",transport="retry"} 300
+postfix_delay_seconds_count{postfix_instance="postfix",status="deferred",subprogram="retry",transport="retry"} 1
+postfix_delay_seconds_bucket{postfix_instance="postfix",status="deferred",subprogram="smtp",transport="smtp",le="1"} 2
+postfix_delay_seconds_bucket{postfix_instance="postfix",status="deferred",subprogram="smtp",transport="smtp",le="5"} 4
+postfix_delay_seconds_bucket{postfix_instance="postfix",status="deferred",subprogram="smtp",transport="smtp",le="+Inf"} 4
+postfix_delay_seconds_sum{postfix_instance="postfix",status="deferred",subprogram="smtp",transport="smtp"} 6
+postfix_delay_seconds_count{postfix_instance="postfix",status="deferred",subprogram="smtp",transport="smtp"} 4
+postfix_delay_seconds_bucket{postfix_instance="postfix",status="sent",subprogram="discard",transport="discard",le="1"} 1
+postfix_delay_seconds_bucket{postfix_instance="postfix",status="sent",subprogram="discard",transport="discard",le="5"} 1
+postfix_delay_seconds_bucket{postfix_instance="postfix",status="sent",subprogram="discard",transport="discard",le="+Inf"} 1
+postfix_delay_seconds_sum{postfix_instance="postfix",status="sent",subprogram="discard",transport="discard"} 0.1
+postfix_delay_seconds_count{postfix_instance="postfix",status="sent",subprogram="discard",transport="discard"} 1
+postfix_delay_seconds_bucket{postfix_instance="postfix",status="sent",subprogram="lmtp",transport="lmtp",le="1"} 1
+postfix_delay_seconds_bucket{postfix_instance="postfix",status="sent",subprogram="lmtp",transport="lmtp",le="5"} 2
+postfix_delay_seconds_bucket{postfix_instance="postfix",status="sent",subprogram="lmtp",transport="lmtp",le="+Inf"} 2
+postfix_delay_seconds_sum{postfix_instance="postfix",status="sent",subprogram="lmtp",transport="lmtp"} 2.12
+postfix_delay_seconds_count{postfix_instance="postfix",status="sent",subprogram="lmtp",transport="lmtp"} 2
+postfix_delay_seconds_bucket{postfix_instance="postfix",status="sent",subprogram="local",transport="local",le="1"} 1
+postfix_delay_seconds_bucket{postfix_instance="postfix",status="sent",subprogram="local",transport="local",le="5"} 1
+postfix_delay_seconds_bucket{postfix_instance="postfix",status="sent",subprogram="local",transport="local",le="+Inf"} 1
+postfix_delay_seconds_sum{postfix_instance="postfix",status="sent",subprogram="local",transport="local"} 0.5
+postfix_delay_seconds_count{postfix_instance="postfix",status="sent",subprogram="local",transport="local"} 1
+postfix_delay_seconds_bucket{postfix_instance="postfix",status="sent",subprogram="pipe",transport="pipe",le="1"} 1
+postfix_delay_seconds_bucket{postfix_instance="postfix",status="sent",subprogram="pipe",transport="pipe",le="5"} 1
+postfix_delay_seconds_bucket{postfix_instance="postfix",status="sent",subprogram="pipe",transport="pipe",le="+Inf"} 1
+postfix_delay_seconds_sum{postfix_instance="postfix",status="sent",subprogram="pipe",transport="pipe"} 0.4
+postfix_delay_seconds_count{postfix_instance="postfix",status="sent",subprogram="pipe",transport="pipe"} 1
+postfix_delay_seconds_bucket{postfix_instance="postfix",status="sent",subprogram="relay/smtp",transport="smtp",le="1"} 1
+postfix_delay_seconds_bucket{postfix_instance="postfix",status="sent",subprogram="relay/smtp",transport="smtp",le="5"} 1
+postfix_delay_seconds_bucket{postfix_instance="postfix",status="sent",subprogram="relay/smtp",transport="smtp",le="+Inf"} 1
+postfix_delay_seconds_sum{postfix_instance="postfix",status="sent",subprogram="relay/smtp",transport="smtp"} 0.5
+postfix_delay_seconds_count{postfix_instance="postfix",status="sent",subprogram="relay/smtp",transport="smtp"} 1
+postfix_delay_seconds_bucket{postfix_instance="postfix",status="sent",subprogram="smtp",transport="smtp",le="1"} 4
+postfix_delay_seconds_bucket{postfix_instance="postfix",status="sent",subprogram="smtp",transport="smtp",le="5"} 4
+postfix_delay_seconds_bucket{postfix_instance="postfix",status="sent",subprogram="smtp",transport="smtp",le="+Inf"} 5
+postfix_delay_seconds_sum{postfix_instance="postfix",status="sent",subprogram="smtp",transport="smtp"} 12.24
+postfix_delay_seconds_count{postfix_instance="postfix",status="sent",subprogram="smtp",transport="smtp"} 5
+postfix_delay_seconds_bucket{postfix_instance="postfix",status="sent",subprogram="virtual",transport="virtual",le="1"} 1
+postfix_delay_seconds_bucket{postfix_instance="postfix",status="sent",subprogram="virtual",transport="virtual",le="5"} 1
+postfix_delay_seconds_bucket{postfix_instance="postfix",status="sent",subprogram="virtual",transport="virtual",le="+Inf"} 1
+postfix_delay_seconds_sum{postfix_instance="postfix",status="sent",subprogram="virtual",transport="virtual"} 0.3
+postfix_delay_seconds_count{postfix_instance="postfix",status="sent",subprogram="virtual",transport="virtual"} 1
+postfix_delay_seconds_bucket{postfix_instance="postfix-out",status="sent",subprogram="smtp",transport="smtp",le="1"} 0
+postfix_delay_seconds_bucket{postfix_instance="postfix-out",status="sent",subprogram="smtp",transport="smtp",le="5"} 1
+postfix_delay_seconds_bucket{postfix_instance="postfix-out",status="sent",subprogram="smtp",transport="smtp",le="+Inf"} 1
+postfix_delay_seconds_sum{postfix_instance="postfix-out",status="sent",subprogram="smtp",transport="smtp"} 2
+postfix_delay_seconds_count{postfix_instance="postfix-out",status="sent",subprogram="smtp",transport="smtp"} 1
+`
+	if err := testutil.CollectAndCompare(exporter, strings.NewReader(metrics), "postfix_delay_seconds"); err != nil {
+		t.Errorf("testutil.CollectAndCompare() = %v; want nil", err)
+	}
+}
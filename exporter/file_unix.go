@@ -0,0 +1,26 @@
+//go:build unix
+
+package exporter
+
+import (
+	"os"
+	"syscall"
+)
+
+func fileInode(fi os.FileInfo) uint64 {
+	if st, ok := fi.Sys().(*syscall.Stat_t); ok {
+		return uint64(st.Ino)
+	}
+	return 0
+}
+
+// unblockFIFO opens a FIFO for writing without blocking, so a blocked reader gets EOF.
+func unblockFIFO(name string) {
+	fi, err := os.Stat(name)
+	if err != nil || fi.Mode()&os.ModeNamedPipe == 0 {
+		return
+	}
+	if f, err := os.OpenFile(name, os.O_WRONLY|syscall.O_NONBLOCK, 0); err == nil {
+		f.Close()
+	}
+}
@@ -4,26 +4,44 @@ package exporter
 
 import (
 	"cmp"
+	"errors"
 	"fmt"
 	"io"
+	"os"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/coreos/go-systemd/v22/sdjournal"
+	"github.com/prometheus/client_golang/prometheus"
 )
 
 // Journald collects Postfix logs from systemd journal.
 type Journald struct {
-	Path  string
-	Unit  string
-	Since time.Duration
-	Test  bool
+	Path       string
+	Unit       string
+	Since      time.Duration
+	CursorFile string
+	Test       bool
 
-	r      *sdjournal.JournalReader
-	closed bool
-	done   chan time.Time
-	wg     sync.WaitGroup
+	r        *sdjournal.JournalReader
+	tail     *sdjournal.Journal
+	mu       sync.Mutex
+	entry    *sdjournal.JournalEntry
+	cursor   atomic.Pointer[string]
+	realtime atomic.Uint64
+	lag      prometheus.GaugeFunc
+	lagOnce  sync.Once
+	closed   bool
+	done     chan time.Time
+	wg       sync.WaitGroup
+
+	// resume is the cursor of the last processed entry seeked to at start
+	// and resumeTime is its time.
+	resume     string
+	resumeTime uint64
 }
 
 func (j *Journald) Collect(ch chan<- result) error {
@@ -34,7 +52,7 @@ func (j *Journald) Collect(ch chan<- result) error {
 	return j.start(ch)
 }
 
-func (j *Journald) open() (*sdjournal.JournalReader, error) {
+func (j *Journald) matches() []sdjournal.Match {
 	var m []sdjournal.Match
 	if j.Unit != "" {
 		m = append(m, sdjournal.Match{
@@ -42,28 +60,121 @@ func (j *Journald) open() (*sdjournal.JournalReader, error) {
 			Value: j.Unit,
 		})
 	}
+	return m
+}
+
+func (j *Journald) open(cursor string) (*sdjournal.JournalReader, error) {
 	d := cmp.Or(j.Since, -1)
 	if d > 0 {
 		d = -d
 	}
-	return sdjournal.NewJournalReader(sdjournal.JournalReaderConfig{
-		Since:     d,
-		Matches:   m,
-		Path:      j.Path,
-		Formatter: formatJournald,
-	})
+	cfg := sdjournal.JournalReaderConfig{
+		Since:   d,
+		Matches: j.matches(),
+		Path:    j.Path,
+		Formatter: func(entry *sdjournal.JournalEntry) (string, error) {
+			j.entry = entry
+			return formatJournald(entry)
+		},
+	}
+	if cursor != "" {
+		cfg.Since = 0
+		cfg.Cursor = cursor
+		if r, err := sdjournal.NewJournalReader(cfg); err == nil {
+			j.setResume(cursor)
+			return r, nil
+		}
+		// Fall back to Since if the cursor is invalid.
+		cfg.Since, cfg.Cursor = d, ""
+	}
+	return sdjournal.NewJournalReader(cfg)
+}
+
+// setResume sets the cursor of the last processed entry to resume after.
+func (j *Journald) setResume(cursor string) {
+	j.resume = cursor
+	j.resumeTime, _ = cursorRealtime(cursor)
+}
+
+// skip reports whether an entry read after seeking to the resume cursor was already processed.
+// Seeking to a cursor positions at the entry that was already processed, but if the entry
+// no longer exists, like in a vacuumed or rotated journal, the entries up to its time are skipped.
+func (j *Journald) skip(entry *sdjournal.JournalEntry) bool {
+	if j.resume == "" {
+		return false
+	}
+	if entry.Cursor == j.resume {
+		j.resume = ""
+		return true
+	}
+	if entry.RealtimeTimestamp <= j.resumeTime {
+		return true
+	}
+	j.resume = ""
+	return false
+}
+
+// cursorRealtime returns the realtime timestamp in microseconds from the t= field of a cursor.
+func cursorRealtime(cursor string) (uint64, bool) {
+	for _, field := range strings.Split(cursor, ";") {
+		if s, ok := strings.CutPrefix(field, "t="); ok {
+			t, err := strconv.ParseUint(s, 16, 64)
+			return t, err == nil
+		}
+	}
+	return 0, false
+}
+
+// loadCursor returns the cursor saved in the cursor file or an empty string.
+func (j *Journald) loadCursor() (string, error) {
+	b, err := os.ReadFile(j.CursorFile)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return "", errors.New("error reading cursor file: " + err.Error())
+	}
+	return strings.TrimSpace(string(b)), nil
 }
 
 func (j *Journald) start(ch chan<- result) error {
-	var err error
-	j.r, err = j.open()
+	var (
+		cursor string
+		err    error
+	)
+	if j.CursorFile != "" {
+		if cursor, err = j.loadCursor(); err != nil {
+			return err
+		}
+	}
+	j.r, err = j.open(cursor)
 	if err != nil {
 		return err
 	}
+	if j.tail, err = j.openTail(); err != nil {
+		j.r.Close()
+		return err
+	}
 	j.wg.Add(1)
 	go func() {
 		defer j.wg.Done()
+		if j.CursorFile != "" {
+			j.wg.Add(1)
+			go func() {
+				defer j.wg.Done()
+				ticker := time.NewTicker(time.Second)
+				defer ticker.Stop()
+				for {
+					select {
+					case <-ticker.C:
+						j.saveCursor()
+					case <-j.done:
+						return
+					}
+				}
+			}()
+		}
 		j.r.Follow(j.done, writerFunc(func(p []byte) (n int, err error) {
+			if j.skip(j.entry) {
+				return len(p), nil
+			}
 			var res result
 			res.rec, res.err = parseRecord(string(p))
 			select {
@@ -71,14 +182,92 @@ func (j *Journald) start(ch chan<- result) error {
 			case <-j.done:
 				return
 			}
+			j.cursor.Store(&j.entry.Cursor)
+			j.realtime.Store(j.entry.RealtimeTimestamp)
 			return len(p), nil
 		}))
 	}()
 	return nil
 }
 
+func (j *Journald) openTail() (*sdjournal.Journal, error) {
+	var (
+		t   *sdjournal.Journal
+		err error
+	)
+	if j.Path != "" {
+		t, err = sdjournal.NewJournalFromDir(j.Path)
+	} else {
+		t, err = sdjournal.NewJournal()
+	}
+	if err != nil {
+		return nil, err
+	}
+	for _, m := range j.matches() {
+		if err = t.AddMatch(m.String()); err != nil {
+			t.Close()
+			return nil, err
+		}
+	}
+	return t, nil
+}
+
+func (j *Journald) saveCursor() {
+	if cursor := j.cursor.Load(); cursor != nil {
+		writeStateFile(j.CursorFile, []byte(*cursor+"\n"))
+	}
+}
+
+// lagSeconds returns the time between the last processed entry
+// and the last entry in the journal.
+func (j *Journald) lagSeconds() float64 {
+	realtime := j.realtime.Load()
+	if realtime == 0 {
+		return 0
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.tail == nil {
+		return 0
+	}
+	// Process journal changes such as rotated files before seeking.
+	j.tail.Wait(0)
+	if err := j.tail.SeekTail(); err != nil {
+		return 0
+	}
+	if _, err := j.tail.Previous(); err != nil {
+		return 0
+	}
+	tail, err := j.tail.GetRealtimeUsec()
+	if err != nil || tail <= realtime {
+		return 0
+	}
+	return float64(tail-realtime) / 1e6
+}
+
+// lagGauge returns the cursor lag gauge, so the metrics can be described
+// before the collector is started.
+func (j *Journald) lagGauge() prometheus.GaugeFunc {
+	j.lagOnce.Do(func() {
+		j.lag = prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "journald_cursor_lag_seconds",
+			Help:      "Time between the last processed systemd journal entry and the last entry in the journal.",
+		}, j.lagSeconds)
+	})
+	return j.lag
+}
+
+func (j *Journald) describeMetrics(ch chan<- *prometheus.Desc) {
+	j.lagGauge().Describe(ch)
+}
+
+func (j *Journald) collectMetrics(ch chan<- prometheus.Metric) {
+	j.lagGauge().Collect(ch)
+}
+
 func (j *Journald) read(ch chan<- result) error {
-	r, err := j.open()
+	r, err := j.open("")
 	if err != nil {
 		return err
 	}
@@ -121,8 +310,18 @@ func (j *Journald) Close() error {
 	close(j.done)
 	var err error
 	if j.r != nil {
+		j.wg.Wait()
+		if j.CursorFile != "" {
+			j.saveCursor()
+		}
 		err = j.r.Close()
 	}
+	j.mu.Lock()
+	if j.tail != nil {
+		j.tail.Close()
+		j.tail = nil
+	}
+	j.mu.Unlock()
 	return err
 }
 
@@ -0,0 +1,197 @@
+//go:build linux && cgo
+
+package exporter
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/coreos/go-systemd/v22/journal"
+	"github.com/coreos/go-systemd/v22/sdjournal"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const testCursor = "s=739ad463348b4ceca5a9e69c95a3c93f;i=4ece7;b=6c7c6013a8ee4b3b8b4d5e6f7a8b9c0d;m=139a1dcd4;t=5e3b7c1a2f4e0;x=62bd2c4c6f1f2f3a"
+
+func TestCursorRealtime(t *testing.T) {
+	tests := []struct {
+		cursor string
+		t      uint64
+		ok     bool
+	}{
+		{cursor: testCursor, t: 0x5e3b7c1a2f4e0, ok: true},
+		{cursor: "s=1;i=2", ok: false},
+		{cursor: "s=1;t=invalid", ok: false},
+		{cursor: "", ok: false},
+	}
+	for _, test := range tests {
+		t.Run(test.cursor, func(t *testing.T) {
+			if tm, ok := cursorRealtime(test.cursor); tm != test.t || ok != test.ok {
+				t.Errorf("cursorRealtime(%q) = %d, %v; want %d, %v", test.cursor, tm, ok, test.t, test.ok)
+			}
+		})
+	}
+}
+
+func TestJournald_Skip(t *testing.T) {
+	resumeTime, _ := cursorRealtime(testCursor)
+	tests := []struct {
+		name    string
+		entries []sdjournal.JournalEntry
+		skipped []bool
+	}{
+		{
+			name: "processed entry",
+			entries: []sdjournal.JournalEntry{
+				{Cursor: testCursor, RealtimeTimestamp: resumeTime},
+				{Cursor: "next", RealtimeTimestamp: resumeTime},
+			},
+			skipped: []bool{true, false},
+		},
+		{
+			name: "stale cursor",
+			entries: []sdjournal.JournalEntry{
+				{Cursor: "older", RealtimeTimestamp: resumeTime - 1},
+				{Cursor: "same time", RealtimeTimestamp: resumeTime},
+				{Cursor: "newer", RealtimeTimestamp: resumeTime + 1},
+				{Cursor: "older after newer", RealtimeTimestamp: resumeTime - 1},
+			},
+			skipped: []bool{true, true, false, false},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var j Journald
+			j.setResume(testCursor)
+			for i, entry := range test.entries {
+				if skipped := j.skip(&entry); skipped != test.skipped[i] {
+					t.Errorf("skip(%q) = %v; want %v", entry.Cursor, skipped, test.skipped[i])
+				}
+			}
+		})
+	}
+}
+
+func TestJournald_SaveCursor(t *testing.T) {
+	j := Journald{CursorFile: filepath.Join(t.TempDir(), "cursor")}
+	cursor, err := j.loadCursor()
+	if err != nil {
+		t.Fatalf("loadCursor() = _, %v; want nil", err)
+	}
+	if cursor != "" {
+		t.Errorf("loadCursor() = %q, _; want empty", cursor)
+	}
+	j.saveCursor()
+	if _, err := os.Stat(j.CursorFile); err == nil {
+		t.Errorf("saveCursor() created %q with no processed entries", j.CursorFile)
+	}
+	s := testCursor
+	j.cursor.Store(&s)
+	j.saveCursor()
+	cursor, err = j.loadCursor()
+	if err != nil {
+		t.Fatalf("loadCursor() = _, %v; want nil", err)
+	}
+	if cursor != testCursor {
+		t.Errorf("loadCursor() = %q, _; want %q", cursor, testCursor)
+	}
+}
+
+func TestJournald_LagSeconds(t *testing.T) {
+	var j Journald
+	if lag := j.lagSeconds(); lag != 0 {
+		t.Errorf("lagSeconds() = %v; want 0 with no processed entries", lag)
+	}
+	j.realtime.Store(1)
+	if lag := j.lagSeconds(); lag != 0 {
+		t.Errorf("lagSeconds() = %v; want 0 with no journal", lag)
+	}
+	if !journal.Enabled() {
+		t.Skip("journald is not available")
+	}
+	tail, err := j.openTail()
+	if err != nil {
+		t.Skip(err)
+	}
+	j.tail = tail
+	defer tail.Close()
+	if lag := j.lagSeconds(); lag <= 0 {
+		t.Errorf("lagSeconds() = %v; want > 0 for an entry at the Unix epoch", lag)
+	}
+}
+
+func TestJournald_Resume(t *testing.T) {
+	if !journal.Enabled() {
+		t.Skip("journald is not available")
+	}
+	cursorFile := filepath.Join(t.TempDir(), "cursor")
+	identifier := "postfix-exporter-test-" + strconv.FormatInt(time.Now().UnixNano(), 36)
+	send := func(text string) {
+		t.Helper()
+		if err := journal.Send(text, journal.PriInfo, map[string]string{"SYSLOG_IDENTIFIER": identifier}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// receive returns the texts of the test records until the one with last.
+	receive := func(ch <-chan result, last string) []string {
+		t.Helper()
+		var texts []string
+		timeout := time.After(10 * time.Second)
+		for {
+			select {
+			case res := <-ch:
+				if res.err != nil || res.rec.Program != identifier {
+					continue
+				}
+				texts = append(texts, res.rec.Text)
+				if res.rec.Text == last {
+					return texts
+				}
+			case <-timeout:
+				t.Fatalf("timed out waiting for %q, received %q", last, texts)
+			}
+		}
+	}
+	send("first")
+	j := &Journald{Since: time.Minute, CursorFile: cursorFile}
+	ch := make(chan result)
+	if err := j.Collect(ch); err != nil {
+		t.Fatalf("Collect() = %v; want nil", err)
+	}
+	receive(ch, "first")
+	go func() {
+		for range ch {
+		}
+	}()
+	if err := j.Close(); err != nil {
+		t.Fatalf("Close() = %v; want nil", err)
+	}
+	close(ch)
+	if _, err := os.Stat(cursorFile); err != nil {
+		t.Fatalf("cursor file was not saved: %v", err)
+	}
+
+	send("second")
+	j = &Journald{Since: time.Minute, CursorFile: cursorFile}
+	ch = make(chan result)
+	if err := j.Collect(ch); err != nil {
+		t.Fatalf("Collect() = %v; want nil", err)
+	}
+	defer j.Close()
+	if texts := receive(ch, "second"); len(texts) != 1 {
+		t.Errorf("received %q after resuming; want [\"second\"]", texts)
+	}
+}
+
+func TestJournald_DescribeMetrics(t *testing.T) {
+	var j Journald
+	ch := make(chan *prometheus.Desc, 1)
+	j.describeMetrics(ch)
+	if desc := <-ch; !strings.Contains(desc.String(), "postfix_journald_cursor_lag_seconds") {
+		t.Errorf("describeMetrics() = %v; want postfix_journald_cursor_lag_seconds", desc)
+	}
+}
@@ -30,7 +30,7 @@ func TestExporter_Journald_Collect(t *testing.T) {
 					t.Fatal(err)
 				}
 			}
-			exporter, err := New(&Journald{}, "postfix", cfg, promslog.NewNopLogger())
+			exporter, err := New(&Journald{}, testInstance, cfg, promslog.NewNopLogger())
 			if errors.Is(err, ErrUnsupportedCollector) {
 				t.Skip(err)
 			}
@@ -99,14 +99,14 @@ func TestExporter_Journald_Test_Simple(t *testing.T) {
 				Since: time.Duration(-1) * time.Hour,
 				Test:  true,
 			}
-			exporter, err := New(collector, "postfix", cfg, promslog.NewNopLogger())
+			exporter, err := New(collector, testInstance, cfg, promslog.NewNopLogger())
 			if errors.Is(err, ErrUnsupportedCollector) {
 				t.Skip(err)
 			}
 			if err != nil {
 				t.Fatalf("New() = _, %v; want nil", err)
 			}
-			collector.Wait()
+			exporter.Wait()
 			if _, err := testutil.CollectAndFormat(exporter, expfmt.TypeTextPlain, testMetrics...); err != nil {
 				t.Errorf("testutil.CollectAndFormat() = _, %v; want nil", err)
 			}
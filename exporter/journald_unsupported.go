@@ -6,10 +6,11 @@ import "time"
 
 // Journald collects Postfix logs from journald.
 type Journald struct {
-	Path  string
-	Unit  string
-	Since time.Duration
-	Test  bool
+	Path       string
+	Unit       string
+	Since      time.Duration
+	CursorFile string
+	Test       bool
 }
 
 func (*Journald) Collect(chan<- result) error { return ErrUnsupportedCollector }
@@ -0,0 +1,136 @@
+package exporter
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"maps"
+	"slices"
+	"strings"
+
+	"github.com/sergeymakinen/postfix_exporter/v2/config"
+)
+
+// otherLabelValue replaces label values exceeding the limits.
+const otherLabelValue = "other"
+
+// labelLimiter caps the number of distinct values of a label.
+type labelLimiter struct {
+	allowlist map[string]bool
+	hash      bool
+	max       int
+	seen      map[string]bool
+}
+
+func newLabelLimiter(allowlist []string, hash bool, max int) *labelLimiter {
+	l := &labelLimiter{
+		hash: hash,
+		max:  max,
+		seen: make(map[string]bool),
+	}
+	if len(allowlist) > 0 {
+		l.allowlist = make(map[string]bool, len(allowlist))
+		for _, s := range allowlist {
+			l.allowlist[s] = true
+		}
+	}
+	return l
+}
+
+// value returns the label value for s: either s itself, its hash if hashing
+// is enabled, or otherLabelValue if s is not allowlisted or the maximum number
+// of distinct values is reached.
+func (l *labelLimiter) value(s string) string {
+	if l.allowlist != nil && !l.allowlist[s] {
+		return otherLabelValue
+	}
+	if l.hash {
+		sum := sha256.Sum256([]byte(s))
+		s = hex.EncodeToString(sum[:8])
+	}
+	if !l.seen[s] {
+		if l.max > 0 && len(l.seen) >= l.max {
+			return otherLabelValue
+		}
+		l.seen[s] = true
+	}
+	return s
+}
+
+// carry keeps reporting the values seen by the old limiter, such as before
+// a configuration reload, if they're still reported as is and kept by keep,
+// if not nil, up to the maximum.
+func (l *labelLimiter) carry(old *labelLimiter, keep func(s string) bool) {
+	if old == nil || old.hash != l.hash {
+		return
+	}
+	for _, s := range slices.Sorted(maps.Keys(old.seen)) {
+		// Hashed values can't be checked against the allowlist.
+		if l.allowlist != nil && (l.hash || !l.allowlist[s]) {
+			continue
+		}
+		if keep != nil && !keep(s) {
+			continue
+		}
+		if l.max > 0 && len(l.seen) >= l.max {
+			break
+		}
+		l.seen[s] = true
+	}
+}
+
+// domainLimiter caps the number of distinct domain label values. A domain is
+// reported as the name of the first group matching it, an allowlisted domain
+// it belongs to, or as is, if no allowlist is set.
+type domainLimiter struct {
+	groups    []config.DomainGroupConfig
+	allowlist []string
+	limiter   *labelLimiter
+}
+
+func newDomainLimiter(cfg *config.DeliveryDomainsConfig, max int) *domainLimiter {
+	return &domainLimiter{
+		groups:    cfg.Groups,
+		allowlist: cfg.Allowlist,
+		limiter:   newLabelLimiter(nil, false, max),
+	}
+}
+
+// value returns the label value for a domain or otherLabelValue if it's
+// not allowlisted or the maximum number of distinct values is reached.
+func (l *domainLimiter) value(domain string) string {
+	domain = strings.ToLower(strings.TrimSuffix(domain, "."))
+	if name, ok := l.group(domain); ok {
+		return name
+	}
+	if len(l.allowlist) == 0 {
+		return l.limiter.value(domain)
+	}
+	for _, s := range l.allowlist {
+		if domain == s || strings.HasSuffix(domain, "."+s) {
+			return s
+		}
+	}
+	return otherLabelValue
+}
+
+// group returns the name of the first group matching a domain.
+func (l *domainLimiter) group(domain string) (string, bool) {
+	for _, group := range l.groups {
+		if group.Regexp.MatchString(domain) {
+			return group.Name, true
+		}
+	}
+	return "", false
+}
+
+// carry keeps reporting the domains seen by the old limiter, such as before
+// a configuration reload, if they're still reported as is.
+func (l *domainLimiter) carry(old *domainLimiter) {
+	if old == nil || len(l.allowlist) > 0 {
+		return
+	}
+	l.limiter.carry(old.limiter, func(domain string) bool {
+		_, ok := l.group(domain)
+		return !ok
+	})
+}
@@ -0,0 +1,133 @@
+package exporter
+
+import (
+	"regexp"
+	"testing"
+
+	"github.com/sergeymakinen/postfix_exporter/v2/config"
+	"gopkg.in/yaml.v3"
+)
+
+func TestLabelLimiter_Value(t *testing.T) {
+	tests := []struct {
+		Name    string
+		Limiter *labelLimiter
+		Values  []string
+		Want    []string
+	}{
+		{
+			Name:    "max",
+			Limiter: newLabelLimiter(nil, false, 2),
+			Values:  []string{"a", "b", "c", "a"},
+			Want:    []string{"a", "b", "other", "a"},
+		},
+		{
+			Name:    "allowlist",
+			Limiter: newLabelLimiter([]string{"a", "c"}, false, 0),
+			Values:  []string{"a", "b", "c"},
+			Want:    []string{"a", "other", "c"},
+		},
+		{
+			Name:    "hash",
+			Limiter: newLabelLimiter(nil, true, 1),
+			Values:  []string{"user@example.com", "user2@example.com"},
+			Want:    []string{"b4c9a289323b21a0", "other"},
+		},
+	}
+	for _, test := range tests {
+		for i, s := range test.Values {
+			if got := test.Limiter.value(s); got != test.Want[i] {
+				t.Errorf("%s: value(%q) = %q; want %q", test.Name, s, got, test.Want[i])
+			}
+		}
+	}
+}
+
+func TestDomainLimiter_Value(t *testing.T) {
+	groups := []config.DomainGroupConfig{
+		{Regexp: &config.Regexp{Regexp: regexp.MustCompile(`^(gmail|googlemail)\.com$`)}, Name: "google"},
+	}
+	tests := []struct {
+		Name    string
+		Limiter *domainLimiter
+		Values  []string
+		Want    []string
+	}{
+		{
+			Name:    "max",
+			Limiter: newDomainLimiter(&config.DeliveryDomainsConfig{}, 2),
+			Values:  []string{"example.com", "Example.ORG.", "example.net", "example.com"},
+			Want:    []string{"example.com", "example.org", "other", "example.com"},
+		},
+		{
+			Name:    "allowlist",
+			Limiter: newDomainLimiter(&config.DeliveryDomainsConfig{Allowlist: []string{"example.com"}}, 0),
+			Values:  []string{"mx.example.com", "example.com", "notexample.com"},
+			Want:    []string{"example.com", "example.com", "other"},
+		},
+		{
+			Name:    "groups",
+			Limiter: newDomainLimiter(&config.DeliveryDomainsConfig{Groups: groups}, 1),
+			Values:  []string{"gmail.com", "example.com", "googlemail.com", "example.org"},
+			Want:    []string{"google", "example.com", "google", "other"},
+		},
+	}
+	for _, test := range tests {
+		for i, s := range test.Values {
+			if got := test.Limiter.value(s); got != test.Want[i] {
+				t.Errorf("%s: value(%q) = %q; want %q", test.Name, s, got, test.Want[i])
+			}
+		}
+	}
+}
+
+func TestLabelLimiter_Carry(t *testing.T) {
+	old := newLabelLimiter(nil, false, 0)
+	for _, s := range []string{"a", "b", "c"} {
+		old.value(s)
+	}
+	l := newLabelLimiter([]string{"a", "b", "d"}, false, 3)
+	l.carry(old, nil)
+	for s, want := range map[string]string{"a": "a", "c": "other", "d": "d", "e": "other"} {
+		if got := l.value(s); got != want {
+			t.Errorf("value(%q) = %q; want %q", s, got, want)
+		}
+	}
+	l = newLabelLimiter(nil, false, 1)
+	l.carry(old, nil)
+	for s, want := range map[string]string{"a": "a", "b": "other"} {
+		if got := l.value(s); got != want {
+			t.Errorf("value(%q) = %q; want %q", s, got, want)
+		}
+	}
+}
+
+func TestDomainLimiter_Carry(t *testing.T) {
+	old := newDomainLimiter(&config.DeliveryDomainsConfig{}, 0)
+	for _, s := range []string{"example.com", "gmail.com", "example.org"} {
+		old.value(s)
+	}
+	groups := []config.DomainGroupConfig{
+		{Regexp: &config.Regexp{Regexp: regexp.MustCompile(`^gmail\.com$`)}, Name: "google"},
+	}
+	l := newDomainLimiter(&config.DeliveryDomainsConfig{Groups: groups}, 2)
+	l.carry(old)
+	for s, want := range map[string]string{"gmail.com": "google", "example.com": "example.com", "example.org": "example.org", "example.net": "other"} {
+		if got := l.value(s); got != want {
+			t.Errorf("value(%q) = %q; want %q", s, got, want)
+		}
+	}
+}
+
+func TestDomainGroupConfig_Regexp(t *testing.T) {
+	var group config.DomainGroupConfig
+	if err := yaml.Unmarshal([]byte("{regexp: 'gmail\\.com|googlemail\\.com', name: google}"), &group); err != nil {
+		t.Fatal(err)
+	}
+	l := newDomainLimiter(&config.DeliveryDomainsConfig{Groups: []config.DomainGroupConfig{group}}, 0)
+	for s, want := range map[string]string{"gmail.com": "google", "googlemail.com": "google", "notgmail.com": "notgmail.com", "gmail.com.example.org": "gmail.com.example.org"} {
+		if got := l.value(s); got != want {
+			t.Errorf("value(%q) = %q; want %q", s, got, want)
+		}
+	}
+}
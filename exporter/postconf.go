@@ -0,0 +1,427 @@
+package exporter
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"errors"
+	"io"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// postconfTimeout limits the time to run the postconf command.
+const postconfTimeout = 30 * time.Second
+
+// maxExpansionDepth limits nested $name expansions in main.cf.
+const maxExpansionDepth = 100
+
+var reSettingName = regexp.MustCompile(`^[a-z_][a-z0-9_]*$`)
+
+// runtimeParameters are read-only parameters postconf reports for its own process,
+// which change on every run and aren't configuration.
+var runtimeParameters = []string{"process_id", "process_name"}
+
+// Postconf collects selected Postfix configuration settings, either running
+// the postconf command or parsing main.cf and master.cf in a configuration directory.
+// It implements prometheus.Collector.
+type Postconf struct {
+	command  []string
+	dir      string
+	settings []string
+	interval time.Duration
+	logger   *slog.Logger
+	now      func() time.Time
+
+	mu          sync.Mutex
+	values      []string
+	fingerprint []byte
+	changed     time.Time
+	done        chan struct{}
+	wg          sync.WaitGroup
+	errors      prometheus.Counter
+
+	info       *prometheus.Desc
+	lastChange *prometheus.Desc
+}
+
+// NewPostconf returns a Postfix configuration collector exporting settings.
+// If dir is empty, command is run instead of parsing main.cf and master.cf in dir.
+// The command must print all settings as "name = value" lines with $name expanded, like postconf -x.
+// If interval is zero, the configuration is read on every scrape, otherwise every interval.
+func NewPostconf(command []string, dir string, settings []string, interval time.Duration, logger *slog.Logger) (*Postconf, error) {
+	if dir == "" && len(command) == 0 {
+		return nil, errors.New("no postconf command or configuration directory")
+	}
+	for i, name := range settings {
+		if !reSettingName.MatchString(name) {
+			return nil, errors.New("invalid setting name " + strconv.Quote(name))
+		}
+		if slices.Contains(settings[:i], name) {
+			return nil, errors.New("duplicate setting name " + strconv.Quote(name))
+		}
+	}
+	p := &Postconf{
+		command:  command,
+		dir:      dir,
+		settings: settings,
+		interval: interval,
+		logger:   logger,
+		now:      time.Now,
+		done:     make(chan struct{}),
+
+		errors: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "config_read_errors_total",
+			Help:      "Total number of errors reading the Postfix configuration.",
+		}),
+		info: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "config", "info"),
+			"Selected Postfix configuration settings.",
+			settings, nil,
+		),
+		lastChange: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "config", "last_change_timestamp_seconds"),
+			"Timestamp of the last observed Postfix configuration change.",
+			nil, nil,
+		),
+	}
+	if p.interval > 0 {
+		p.update()
+		p.wg.Add(1)
+		go func() {
+			defer p.wg.Done()
+			ticker := time.NewTicker(p.interval)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-ticker.C:
+					p.update()
+				case <-p.done:
+					return
+				}
+			}
+		}()
+	}
+	return p, nil
+}
+
+// Describe implements prometheus.Collector.
+func (p *Postconf) Describe(ch chan<- *prometheus.Desc) {
+	p.errors.Describe(ch)
+	ch <- p.info
+	ch <- p.lastChange
+}
+
+// Collect implements prometheus.Collector.
+func (p *Postconf) Collect(ch chan<- prometheus.Metric) {
+	if p.interval == 0 {
+		p.update()
+	}
+	p.errors.Collect(ch)
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.values == nil {
+		return
+	}
+	ch <- prometheus.MustNewConstMetric(p.info, prometheus.GaugeValue, 1, p.values...)
+	ch <- prometheus.MustNewConstMetric(p.lastChange, prometheus.GaugeValue, float64(p.changed.UnixNano())/1e9)
+}
+
+// Close stops reading the configuration.
+func (p *Postconf) Close() error {
+	close(p.done)
+	p.wg.Wait()
+	return nil
+}
+
+func (p *Postconf) update() {
+	var (
+		params   map[string]string
+		master   []string
+		modified time.Time
+		err      error
+	)
+	if p.dir != "" {
+		params, master, modified, err = p.readDir()
+	} else {
+		params, err = p.run()
+		modified = p.configModified(params["config_directory"])
+	}
+	if err != nil {
+		p.errors.Inc()
+		p.logger.Warn("Error reading Postfix configuration", "err", err)
+		return
+	}
+	values := make([]string, len(p.settings))
+	for i, name := range p.settings {
+		values[i] = params[name]
+	}
+	fingerprint := configFingerprint(params, master)
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	switch {
+	case p.fingerprint == nil:
+		// The modification time is the best guess at startup.
+		p.changed = modified
+	case !bytes.Equal(p.fingerprint, fingerprint):
+		p.changed = p.now()
+	}
+	p.values = values
+	p.fingerprint = fingerprint
+}
+
+func (p *Postconf) run() (map[string]string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), postconfTimeout)
+	defer cancel()
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, p.command[0], p.command[1:]...)
+	cmd.Stderr = &stderr
+	b, err := cmd.Output()
+	if err != nil {
+		if s := strings.TrimSpace(stderr.String()); s != "" {
+			return nil, errors.New(err.Error() + ": " + s)
+		}
+		return nil, err
+	}
+	return parseMainCf(bytes.NewReader(b))
+}
+
+func (p *Postconf) readDir() (map[string]string, []string, time.Time, error) {
+	var modified time.Time
+	open := func(name string) (*os.File, error) {
+		f, err := os.Open(filepath.Join(p.dir, name))
+		if err != nil {
+			return nil, err
+		}
+		fi, err := f.Stat()
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+		if fi.ModTime().After(modified) {
+			modified = fi.ModTime()
+		}
+		return f, nil
+	}
+	f, err := open("main.cf")
+	if err != nil {
+		return nil, nil, time.Time{}, err
+	}
+	defer f.Close()
+	raw, err := parseMainCf(f)
+	if err != nil {
+		return nil, nil, time.Time{}, errors.New("error parsing main.cf: " + err.Error())
+	}
+	params := make(map[string]string, len(raw))
+	for name := range raw {
+		params[name] = expandParameter(raw, raw[name], 0)
+	}
+	f, err = open("master.cf")
+	if err != nil {
+		return nil, nil, time.Time{}, err
+	}
+	defer f.Close()
+	var master []string
+	err = readLogicalLines(f, func(line string) error {
+		master = append(master, strings.Join(strings.Fields(line), " "))
+		return nil
+	})
+	if err != nil {
+		return nil, nil, time.Time{}, errors.New("error parsing master.cf: " + err.Error())
+	}
+	return params, master, modified, nil
+}
+
+// configModified returns the latest modification time of main.cf and master.cf
+// in a configuration directory or the current time if it's unknown.
+func (p *Postconf) configModified(dir string) time.Time {
+	var modified time.Time
+	if dir != "" {
+		for _, name := range []string{"main.cf", "master.cf"} {
+			if fi, err := os.Stat(filepath.Join(dir, name)); err == nil && fi.ModTime().After(modified) {
+				modified = fi.ModTime()
+			}
+		}
+	}
+	if modified.IsZero() {
+		return p.now()
+	}
+	return modified
+}
+
+// readLogicalLines calls fn for every logical line of a Postfix configuration file.
+// A line starting with whitespace continues a logical line. Empty lines
+// and lines starting with # are skipped.
+func readLogicalLines(r io.Reader, fn func(line string) error) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 1<<20)
+	var line strings.Builder
+	flush := func() error {
+		if line.Len() == 0 {
+			return nil
+		}
+		s := line.String()
+		line.Reset()
+		return fn(s)
+	}
+	for scanner.Scan() {
+		s := scanner.Text()
+		trimmed := strings.TrimSpace(s)
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+			continue
+		}
+		if s[0] == ' ' || s[0] == '\t' {
+			if line.Len() == 0 {
+				return errors.New("unexpected continuation line " + strconv.Quote(s))
+			}
+			line.WriteByte(' ')
+			line.WriteString(trimmed)
+			continue
+		}
+		if err := flush(); err != nil {
+			return err
+		}
+		line.WriteString(strings.TrimRightFunc(s, isSpace))
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	return flush()
+}
+
+// parseMainCf parses "name = value" logical lines as in main.cf or the postconf output.
+func parseMainCf(r io.Reader) (map[string]string, error) {
+	params := make(map[string]string)
+	err := readLogicalLines(r, func(line string) error {
+		name, value, ok := strings.Cut(line, "=")
+		name = strings.TrimSpace(name)
+		if !ok || !reSettingName.MatchString(name) {
+			return errors.New("invalid line " + strconv.Quote(line))
+		}
+		params[name] = strings.TrimSpace(value)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return params, nil
+}
+
+// expandParameter expands $name, ${name} and $(name) references in a value,
+// as well as ${name?value} and ${name:value} conditionals.
+// Unknown parameters expand to an empty string as their defaults are unknown.
+func expandParameter(params map[string]string, value string, depth int) string {
+	if depth > maxExpansionDepth || !strings.Contains(value, "$") {
+		return value
+	}
+	var b strings.Builder
+	for i := 0; i < len(value); i++ {
+		if value[i] != '$' || i+1 == len(value) {
+			b.WriteByte(value[i])
+			continue
+		}
+		i++
+		switch c := value[i]; c {
+		case '$':
+			b.WriteByte('$')
+		case '{', '(':
+			end := matchingBracket(value, i)
+			if end == -1 {
+				b.WriteString(value[i-1:])
+				return b.String()
+			}
+			b.WriteString(expandReference(params, value[i+1:end], depth))
+			i = end
+		default:
+			j := i
+			for j < len(value) && isNameByte(value[j]) {
+				j++
+			}
+			if j == i {
+				b.WriteByte('$')
+				b.WriteByte(c)
+				continue
+			}
+			b.WriteString(expandParameter(params, params[value[i:j]], depth+1))
+			i = j - 1
+		}
+	}
+	return b.String()
+}
+
+// expandReference expands a name, name?value or name:value reference.
+func expandReference(params map[string]string, ref string, depth int) string {
+	i := strings.IndexAny(ref, "?:")
+	if i == -1 {
+		return expandParameter(params, params[strings.TrimSpace(ref)], depth+1)
+	}
+	value := expandParameter(params, params[strings.TrimSpace(ref[:i])], depth+1)
+	alt := ref[i+1:]
+	if len(alt) >= 2 && alt[0] == '{' && alt[len(alt)-1] == '}' {
+		alt = alt[1 : len(alt)-1]
+	}
+	if (ref[i] == '?') == (value != "") {
+		return expandParameter(params, alt, depth+1)
+	}
+	return ""
+}
+
+// matchingBracket returns the index of the bracket closing the one at i or -1.
+func matchingBracket(s string, i int) int {
+	open, closing := s[i], byte('}')
+	if open == '(' {
+		closing = ')'
+	}
+	n := 0
+	for j := i; j < len(s); j++ {
+		switch s[j] {
+		case open:
+			n++
+		case closing:
+			n--
+			if n == 0 {
+				return j
+			}
+		}
+	}
+	return -1
+}
+
+func isNameByte(c byte) bool {
+	return c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9'
+}
+
+func isSpace(r rune) bool {
+	return r == ' ' || r == '\t'
+}
+
+// configFingerprint returns a hash of all parameters but runtime ones and master.cf entries.
+func configFingerprint(params map[string]string, master []string) []byte {
+	h := sha256.New()
+	names := make([]string, 0, len(params))
+	for name := range params {
+		if !slices.Contains(runtimeParameters, name) {
+			names = append(names, name)
+		}
+	}
+	slices.Sort(names)
+	for _, name := range names {
+		io.WriteString(h, name+"="+params[name]+"\n")
+	}
+	for _, line := range master {
+		io.WriteString(h, line+"\n")
+	}
+	return h.Sum(nil)
+}
@@ -0,0 +1,101 @@
+package exporter
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/prometheus/common/promslog"
+)
+
+func TestPostconf_Collect(t *testing.T) {
+	modified := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	now := modified.Add(time.Hour)
+	dir := t.TempDir()
+	for _, name := range []string{"main.cf", "master.cf"} {
+		b, err := os.ReadFile(filepath.Join("testdata", "postconf", name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = os.WriteFile(filepath.Join(dir, name), b, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if err = os.Chtimes(filepath.Join(dir, name), modified, modified); err != nil {
+			t.Fatal(err)
+		}
+	}
+	settings := []string{"inet_protocols", "smtpd_tls_security_level", "mailbox_size_limit", "relayhost", "smtpd_banner", "myorigin"}
+	p, err := NewPostconf(nil, dir, settings, 0, promslog.NewNopLogger())
+	if err != nil {
+		t.Fatalf("NewPostconf() = _, %v; want nil", err)
+	}
+	defer p.Close()
+	p.now = func() time.Time { return now }
+	const metrics = `# HELP postfix_config_info Selected Postfix configuration settings.
+# TYPE postfix_config_info gauge
+postfix_config_info{inet_protocols="ipv4, ipv6",mailbox_size_limit="524288000",myorigin="example.com",relayhost="[relay.example.com]:587",smtpd_banner="mail.example.com ESMTP $mail_name",smtpd_tls_security_level="may"} 1
+# HELP postfix_config_last_change_timestamp_seconds Timestamp of the last observed Postfix configuration change.
+# TYPE postfix_config_last_change_timestamp_seconds gauge
+postfix_config_last_change_timestamp_seconds 1.6725312e+09
+# HELP postfix_config_read_errors_total Total number of errors reading the Postfix configuration.
+# TYPE postfix_config_read_errors_total counter
+postfix_config_read_errors_total 0
+`
+	if err := testutil.CollectAndCompare(p, strings.NewReader(metrics)); err != nil {
+		t.Errorf("testutil.CollectAndCompare() = %v; want nil", err)
+	}
+	f, err := os.OpenFile(filepath.Join(dir, "master.cf"), os.O_APPEND|os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = f.WriteString("submission inet n - y - - smtpd\n"); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	const changed = `# HELP postfix_config_last_change_timestamp_seconds Timestamp of the last observed Postfix configuration change.
+# TYPE postfix_config_last_change_timestamp_seconds gauge
+postfix_config_last_change_timestamp_seconds 1.6725348e+09
+`
+	if err := testutil.CollectAndCompare(p, strings.NewReader(changed), "postfix_config_last_change_timestamp_seconds"); err != nil {
+		t.Errorf("testutil.CollectAndCompare() = %v; want nil", err)
+	}
+}
+
+func TestPostconf_Collect_Command(t *testing.T) {
+	modified := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	dir := t.TempDir()
+	for _, name := range []string{"main.cf", "master.cf"} {
+		if err := os.WriteFile(filepath.Join(dir, name), nil, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(filepath.Join(dir, name), modified, modified); err != nil {
+			t.Fatal(err)
+		}
+	}
+	t.Setenv("POSTCONF_TEST_DIR", dir)
+	settings := []string{"mail_version", "inet_protocols", "message_size_limit", "relayhost"}
+	p, err := NewPostconf([]string{"sh", "testdata/postconf.sh"}, "", settings, time.Hour, promslog.NewNopLogger())
+	if err != nil {
+		t.Fatalf("NewPostconf() = _, %v; want nil", err)
+	}
+	defer p.Close()
+	const metrics = `# HELP postfix_config_info Selected Postfix configuration settings.
+# TYPE postfix_config_info gauge
+postfix_config_info{inet_protocols="ipv4, ipv6",mail_version="3.7.11",message_size_limit="52428800",relayhost=""} 1
+# HELP postfix_config_last_change_timestamp_seconds Timestamp of the last observed Postfix configuration change.
+# TYPE postfix_config_last_change_timestamp_seconds gauge
+postfix_config_last_change_timestamp_seconds 1.6725312e+09
+`
+	if err := testutil.CollectAndCompare(p, strings.NewReader(metrics), "postfix_config_info", "postfix_config_last_change_timestamp_seconds"); err != nil {
+		t.Errorf("testutil.CollectAndCompare() = %v; want nil", err)
+	}
+	// The process_id parameter changing on every run isn't a configuration change.
+	p.now = func() time.Time { return modified.Add(time.Hour) }
+	p.update()
+	if err := testutil.CollectAndCompare(p, strings.NewReader(metrics), "postfix_config_info", "postfix_config_last_change_timestamp_seconds"); err != nil {
+		t.Errorf("testutil.CollectAndCompare() = %v; want nil", err)
+	}
+}
@@ -0,0 +1,293 @@
+package exporter
+
+import (
+	"bufio"
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"os"
+	"os/exec"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// postqueueTimeout limits the time to run the postqueue command.
+const postqueueTimeout = 30 * time.Second
+
+// postqueueQueues are the queue names in the postqueue -j output.
+var postqueueQueues = []string{"maildrop", "hold", "incoming", "active", "deferred"}
+
+// Postqueue collects Postfix queue contents from the postqueue -j output,
+// either running a command or reading a file. It implements prometheus.Collector.
+type Postqueue struct {
+	command  []string
+	file     string
+	interval time.Duration
+	topN     int
+	logger   *slog.Logger
+
+	mu     sync.Mutex
+	stats  *postqueueStats
+	done   chan struct{}
+	wg     sync.WaitGroup
+	errors prometheus.Counter
+
+	messages *prometheus.Desc
+	delayed  *prometheus.Desc
+}
+
+// postqueueMessage is a message in the postqueue -j output.
+type postqueueMessage struct {
+	QueueName  string `json:"queue_name"`
+	QueueID    string `json:"queue_id"`
+	Recipients []struct {
+		Address     string `json:"address"`
+		DelayReason string `json:"delay_reason"`
+	} `json:"recipients"`
+}
+
+type postqueueKey struct {
+	queue  string
+	domain string
+	reason string
+}
+
+type postqueueStats struct {
+	messages map[string]int
+	delayed  map[postqueueKey]int
+}
+
+// NewPostqueue returns a postqueue -j output collector. If file is empty,
+// command is run instead of reading the file. If interval is zero,
+// the output is read on every scrape, otherwise every interval.
+// At most topN recipient domains having the most delayed messages are reported.
+// A FIFO blocks until written to, so it can only be read every interval.
+func NewPostqueue(command []string, file string, interval time.Duration, topN int, logger *slog.Logger) (*Postqueue, error) {
+	if file == "" && len(command) == 0 {
+		return nil, errors.New("no postqueue command or file")
+	}
+	if file != "" && interval == 0 {
+		if fi, err := os.Stat(file); err == nil && fi.Mode()&os.ModeNamedPipe != 0 {
+			return nil, errors.New("reading a FIFO requires a positive interval")
+		}
+	}
+	p := &Postqueue{
+		command:  command,
+		file:     file,
+		interval: interval,
+		topN:     topN,
+		logger:   logger,
+		done:     make(chan struct{}),
+
+		errors: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "postqueue_errors_total",
+			Help:      "Total number of errors reading the postqueue output.",
+		}),
+		messages: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "postqueue", "messages"),
+			"Number of messages in a queue reported by postqueue.",
+			[]string{"queue"}, nil,
+		),
+		delayed: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "postqueue", "delayed_messages"),
+			"Number of messages with delayed recipients reported by postqueue.",
+			[]string{"queue", "domain", "reason"}, nil,
+		),
+	}
+	if p.interval > 0 {
+		p.wg.Add(1)
+		go func() {
+			defer p.wg.Done()
+			// Reading a FIFO blocks until it's written to, so it's first read here.
+			p.update()
+			ticker := time.NewTicker(p.interval)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-ticker.C:
+					p.update()
+				case <-p.done:
+					return
+				}
+			}
+		}()
+	}
+	return p, nil
+}
+
+// Describe implements prometheus.Collector.
+func (p *Postqueue) Describe(ch chan<- *prometheus.Desc) {
+	p.errors.Describe(ch)
+	ch <- p.messages
+	ch <- p.delayed
+}
+
+// Collect implements prometheus.Collector.
+func (p *Postqueue) Collect(ch chan<- prometheus.Metric) {
+	if p.interval == 0 {
+		p.update()
+	}
+	p.errors.Collect(ch)
+	p.mu.Lock()
+	stats := p.stats
+	p.mu.Unlock()
+	if stats == nil {
+		return
+	}
+	for queue, n := range stats.messages {
+		ch <- prometheus.MustNewConstMetric(p.messages, prometheus.GaugeValue, float64(n), queue)
+	}
+	for key, n := range stats.delayed {
+		ch <- prometheus.MustNewConstMetric(p.delayed, prometheus.GaugeValue, float64(n), key.queue, key.domain, key.reason)
+	}
+}
+
+// Close stops reading the postqueue output.
+func (p *Postqueue) Close() error {
+	close(p.done)
+	stopped := make(chan struct{})
+	go func() {
+		p.wg.Wait()
+		close(stopped)
+	}()
+	for {
+		if p.file != "" {
+			// Opening a FIFO for reading blocks until there's a writer.
+			unblockFIFO(p.file)
+		}
+		select {
+		case <-stopped:
+			return nil
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+}
+
+func (p *Postqueue) update() {
+	stats, err := p.read()
+	if err != nil {
+		p.errors.Inc()
+		p.logger.Warn("Error reading postqueue output", "err", err)
+		return
+	}
+	p.mu.Lock()
+	p.stats = stats
+	p.mu.Unlock()
+}
+
+func (p *Postqueue) read() (*postqueueStats, error) {
+	if p.file != "" {
+		f, err := os.Open(p.file)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		return parsePostqueue(f, p.topN)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), postqueueTimeout)
+	defer cancel()
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, p.command[0], p.command[1:]...)
+	cmd.Stderr = &stderr
+	b, err := cmd.Output()
+	if err != nil {
+		if s := strings.TrimSpace(stderr.String()); s != "" {
+			return nil, errors.New(err.Error() + ": " + s)
+		}
+		return nil, err
+	}
+	return parsePostqueue(bytes.NewReader(b), p.topN)
+}
+
+// parsePostqueue parses the postqueue -j output having a JSON object per line.
+// A message is only counted once per domain and reason, and the domains having
+// the most delayed messages but topN are reported as other, if topN is positive.
+func parsePostqueue(r io.Reader, topN int) (*postqueueStats, error) {
+	stats := &postqueueStats{
+		messages: make(map[string]int),
+		delayed:  make(map[postqueueKey]int),
+	}
+	for _, queue := range postqueueQueues {
+		stats.messages[queue] = 0
+	}
+	// delayed holds the delayed recipient keys of every message.
+	var delayed [][]postqueueKey
+	domains := make(map[string]int)
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 1<<20)
+	for scanner.Scan() {
+		if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+			continue
+		}
+		var msg postqueueMessage
+		if err := json.Unmarshal(scanner.Bytes(), &msg); err != nil {
+			return nil, errors.New("error parsing postqueue output: " + err.Error())
+		}
+		stats.messages[msg.QueueName]++
+		var keys []postqueueKey
+		for _, rcpt := range msg.Recipients {
+			if rcpt.DelayReason == "" {
+				continue
+			}
+			key := postqueueKey{
+				queue:  msg.QueueName,
+				domain: recipientDomain(rcpt.Address),
+				reason: classifyDelayReason(rcpt.DelayReason),
+			}
+			if !slices.ContainsFunc(keys, func(k postqueueKey) bool { return k.domain == key.domain }) {
+				domains[key.domain]++
+			}
+			keys = append(keys, key)
+		}
+		if len(keys) > 0 {
+			delayed = append(delayed, keys)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	var allowed map[string]bool
+	if topN > 0 && len(domains) > topN {
+		top := make([]string, 0, len(domains))
+		for domain := range domains {
+			top = append(top, domain)
+		}
+		slices.SortFunc(top, func(a, b string) int {
+			return cmp.Or(cmp.Compare(domains[b], domains[a]), strings.Compare(a, b))
+		})
+		allowed = make(map[string]bool, topN)
+		for _, domain := range top[:topN] {
+			allowed[domain] = true
+		}
+	}
+	for _, keys := range delayed {
+		seen := make(map[postqueueKey]bool, len(keys))
+		for _, key := range keys {
+			if allowed != nil && !allowed[key.domain] {
+				key.domain = otherLabelValue
+			}
+			if !seen[key] {
+				seen[key] = true
+				stats.delayed[key]++
+			}
+		}
+	}
+	return stats, nil
+}
+
+// recipientDomain returns the lowercase domain of an email address.
+func recipientDomain(address string) string {
+	if i := strings.LastIndexByte(address, '@'); i != -1 {
+		return strings.ToLower(address[i+1:])
+	}
+	return "localhost"
+}
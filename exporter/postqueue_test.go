@@ -0,0 +1,78 @@
+package exporter
+
+import (
+	"maps"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/prometheus/common/promslog"
+)
+
+func TestPostqueue_Collect(t *testing.T) {
+	const metrics = `# HELP postfix_postqueue_delayed_messages Number of messages with delayed recipients reported by postqueue.
+# TYPE postfix_postqueue_delayed_messages gauge
+postfix_postqueue_delayed_messages{domain="example.net",queue="deferred",reason="remote_temporary"} 1
+postfix_postqueue_delayed_messages{domain="example.net",queue="hold",reason="lost_connection"} 1
+postfix_postqueue_delayed_messages{domain="example.org",queue="deferred",reason="greylisting"} 1
+postfix_postqueue_delayed_messages{domain="example.org",queue="deferred",reason="timeout"} 1
+postfix_postqueue_delayed_messages{domain="other",queue="deferred",reason="dns"} 1
+# HELP postfix_postqueue_errors_total Total number of errors reading the postqueue output.
+# TYPE postfix_postqueue_errors_total counter
+postfix_postqueue_errors_total 0
+# HELP postfix_postqueue_messages Number of messages in a queue reported by postqueue.
+# TYPE postfix_postqueue_messages gauge
+postfix_postqueue_messages{queue="active"} 1
+postfix_postqueue_messages{queue="deferred"} 3
+postfix_postqueue_messages{queue="hold"} 1
+postfix_postqueue_messages{queue="incoming"} 0
+postfix_postqueue_messages{queue="maildrop"} 0
+`
+	tests := []struct {
+		name    string
+		command []string
+		file    string
+	}{
+		{
+			name:    "command",
+			command: []string{"sh", "testdata/postqueue.sh"},
+		},
+		{
+			name: "file",
+			file: "testdata/postqueue.json",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			p, err := NewPostqueue(test.command, test.file, 0, 2, promslog.NewNopLogger())
+			if err != nil {
+				t.Fatalf("NewPostqueue() = _, %v; want nil", err)
+			}
+			defer p.Close()
+			if err := testutil.CollectAndCompare(p, strings.NewReader(metrics)); err != nil {
+				t.Errorf("testutil.CollectAndCompare() = %v; want nil", err)
+			}
+		})
+	}
+}
+
+func TestParsePostqueue_Other(t *testing.T) {
+	const output = `{"queue_name": "deferred", "queue_id": "0123456789A", "recipients": [{"address": "user@a.example", "delay_reason": "connect to mx.a.example[192.0.2.1]:25: Connection timed out"}, {"address": "user2@a.example", "delay_reason": "host mx.a.example[192.0.2.1] said: 450 4.2.0 Greylisted (in reply to RCPT TO command)"}]}
+{"queue_name": "deferred", "queue_id": "0123456789B", "recipients": [{"address": "user@b.example", "delay_reason": "connect to mx.b.example[192.0.2.2]:25: Connection timed out"}]}
+{"queue_name": "deferred", "queue_id": "0123456789C", "recipients": [{"address": "user@b.example", "delay_reason": "connect to mx.b.example[192.0.2.2]:25: Connection timed out"}]}
+{"queue_name": "deferred", "queue_id": "0123456789D", "recipients": [{"address": "user@c.example", "delay_reason": "Host or domain name not found. Name service error for name=c.example type=MX: Host not found, try again"}, {"address": "user@d.example", "delay_reason": "Host or domain name not found. Name service error for name=d.example type=MX: Host not found, try again"}]}
+`
+	stats, err := parsePostqueue(strings.NewReader(output), 1)
+	if err != nil {
+		t.Fatalf("parsePostqueue() = _, %v; want nil", err)
+	}
+	want := map[postqueueKey]int{
+		{queue: "deferred", domain: "b.example", reason: "timeout"}: 2,
+		{queue: "deferred", domain: "other", reason: "timeout"}:     1,
+		{queue: "deferred", domain: "other", reason: "greylisting"}: 1,
+		{queue: "deferred", domain: "other", reason: "dns"}:         1,
+	}
+	if !maps.Equal(stats.delayed, want) {
+		t.Errorf("parsePostqueue() = %v; want %v", stats.delayed, want)
+	}
+}
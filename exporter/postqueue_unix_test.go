@@ -0,0 +1,69 @@
+//go:build unix
+
+package exporter
+
+import (
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/prometheus/common/promslog"
+)
+
+func TestNewPostqueue_FIFO(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "postqueue.fifo")
+	if err := syscall.Mkfifo(name, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewPostqueue(nil, name, 0, 0, promslog.NewNopLogger()); err == nil {
+		t.Error("NewPostqueue() with a FIFO and zero interval = _, nil; want error")
+	}
+	b, err := os.ReadFile("testdata/postqueue.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The constructor must not block on the FIFO having no writer.
+	created := make(chan *Postqueue)
+	go func() {
+		p, err := NewPostqueue(nil, name, 10*time.Millisecond, 0, promslog.NewNopLogger())
+		if err != nil {
+			t.Errorf("NewPostqueue() = _, %v; want nil", err)
+		}
+		created <- p
+	}()
+	var p *Postqueue
+	select {
+	case p = <-created:
+	case <-time.After(5 * time.Second):
+		t.Fatal("NewPostqueue() blocked")
+	}
+	if p == nil {
+		return
+	}
+	f, err := os.OpenFile(name, os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Write(b)
+	f.Close()
+	for deadline := time.Now().Add(5 * time.Second); testutil.CollectAndCount(p, "postfix_postqueue_messages") != len(postqueueQueues); {
+		if time.Now().After(deadline) {
+			t.Fatal("postqueue output was not read")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	// Close must not block on the FIFO having no writer.
+	done := make(chan struct{})
+	go func() {
+		p.Close()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Error("Close() blocked")
+	}
+}
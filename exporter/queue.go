@@ -0,0 +1,285 @@
+package exporter
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// queueNames are the Postfix queues scanned in the queue directory.
+var queueNames = []string{"maildrop", "incoming", "active", "deferred", "hold"}
+
+// Queue file record types, see src/global/rec_type.h in Postfix sources.
+const (
+	recTypeTime    = 'T'
+	recTypeMessage = 'M'
+)
+
+// maxQueueFileRecords is the number of records to read from a queue file
+// looking for the arrival time.
+const maxQueueFileRecords = 16
+
+// Queue collects Postfix queue sizes by periodically scanning the queue directory.
+// It only needs read access to the queue directory. It implements prometheus.Collector.
+type Queue struct {
+	dir      string
+	interval time.Duration
+	logger   *slog.Logger
+	now      func() time.Time
+
+	mu     sync.Mutex
+	stats  map[string]queueStats
+	done   chan struct{}
+	wg     sync.WaitGroup
+	errors prometheus.Counter
+
+	// arrivals caches arrival times of queue files from the last scan,
+	// zero if unknown, as they never change.
+	arrivals map[queueFileKey]time.Time
+
+	messages *prometheus.Desc
+	bytes    *prometheus.Desc
+	oldest   *prometheus.Desc
+}
+
+type queueStats struct {
+	messages int
+	bytes    int64
+	oldest   time.Time
+}
+
+// queueFileKey identifies a queue file. The queue ID and the inode are kept
+// when a queue file moves between queues, but the inode may be reused.
+type queueFileKey struct {
+	id       string
+	inode    uint64
+	modified int64
+}
+
+// NewQueue returns a queue directory scanner which scans the directory
+// every interval, starting right away.
+func NewQueue(dir string, interval time.Duration, logger *slog.Logger) (*Queue, error) {
+	if _, err := os.Stat(dir); err != nil {
+		return nil, err
+	}
+	q := &Queue{
+		dir:      dir,
+		interval: interval,
+		logger:   logger,
+		now:      time.Now,
+		done:     make(chan struct{}),
+
+		errors: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "queue_scan_errors_total",
+			Help:      "Total number of errors scanning the queue directory.",
+		}),
+		messages: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "queue", "messages"),
+			"Number of messages in a queue.",
+			[]string{"queue"}, nil,
+		),
+		bytes: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "queue", "size_bytes"),
+			"Total size in bytes of queue files in a queue.",
+			[]string{"queue"}, nil,
+		),
+		oldest: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "queue", "oldest_message_age_seconds"),
+			"Age in seconds of the oldest message in a queue.",
+			[]string{"queue"}, nil,
+		),
+	}
+	q.scan()
+	q.wg.Add(1)
+	go func() {
+		defer q.wg.Done()
+		ticker := time.NewTicker(q.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				q.scan()
+			case <-q.done:
+				return
+			}
+		}
+	}()
+	return q, nil
+}
+
+// Describe implements prometheus.Collector.
+func (q *Queue) Describe(ch chan<- *prometheus.Desc) {
+	q.errors.Describe(ch)
+	ch <- q.messages
+	ch <- q.bytes
+	ch <- q.oldest
+}
+
+// Collect implements prometheus.Collector.
+func (q *Queue) Collect(ch chan<- prometheus.Metric) {
+	q.errors.Collect(ch)
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	now := q.now()
+	for _, name := range queueNames {
+		stats, ok := q.stats[name]
+		if !ok {
+			continue
+		}
+		var age float64
+		if !stats.oldest.IsZero() {
+			age = max(now.Sub(stats.oldest).Seconds(), 0)
+		}
+		ch <- prometheus.MustNewConstMetric(q.messages, prometheus.GaugeValue, float64(stats.messages), name)
+		ch <- prometheus.MustNewConstMetric(q.bytes, prometheus.GaugeValue, float64(stats.bytes), name)
+		ch <- prometheus.MustNewConstMetric(q.oldest, prometheus.GaugeValue, age, name)
+	}
+}
+
+// Close stops scanning the queue directory.
+func (q *Queue) Close() error {
+	close(q.done)
+	q.wg.Wait()
+	return nil
+}
+
+func (q *Queue) scan() {
+	stats := make(map[string]queueStats, len(queueNames))
+	arrivals := make(map[queueFileKey]time.Time, len(q.arrivals))
+	for _, name := range queueNames {
+		s, err := q.scanQueue(name, arrivals)
+		if err != nil {
+			q.errors.Inc()
+			q.logger.Warn("Error scanning queue", "queue", name, "err", err)
+			continue
+		}
+		stats[name] = s
+	}
+	q.arrivals = arrivals
+	q.mu.Lock()
+	q.stats = stats
+	q.mu.Unlock()
+}
+
+// scanQueue returns the stats of a queue, adding the arrival times of its files to arrivals.
+func (q *Queue) scanQueue(name string, arrivals map[queueFileKey]time.Time) (queueStats, error) {
+	var stats queueStats
+	err := filepath.WalkDir(filepath.Join(q.dir, name), func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			// Queue files are moved between queues while being scanned.
+			if errors.Is(err, fs.ErrNotExist) && path != filepath.Join(q.dir, name) {
+				return nil
+			}
+			return err
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		fi, err := d.Info()
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+		stats.messages++
+		stats.bytes += fi.Size()
+		key := queueFileKey{
+			id:       d.Name(),
+			inode:    fileInode(fi),
+			modified: fi.ModTime().UnixNano(),
+		}
+		arrival, ok := q.arrivals[key]
+		if !ok {
+			arrival, _ = readQueueFileTime(path)
+		}
+		arrivals[key] = arrival
+		if arrival.IsZero() {
+			if name == "deferred" {
+				// The modification time of deferred queue files
+				// is the time of the next delivery attempt.
+				return nil
+			}
+			arrival = fi.ModTime()
+		}
+		if stats.oldest.IsZero() || arrival.Before(stats.oldest) {
+			stats.oldest = arrival
+		}
+		return nil
+	})
+	return stats, err
+}
+
+// readQueueFileTime returns the message arrival time from a queue file.
+func readQueueFileTime(name string) (time.Time, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return time.Time{}, err
+	}
+	defer f.Close()
+	r := bufio.NewReaderSize(f, 512)
+	for range maxQueueFileRecords {
+		typ, data, err := readQueueFileRecord(r)
+		if err != nil {
+			return time.Time{}, err
+		}
+		switch typ {
+		case recTypeTime:
+			sec, usec, _ := strings.Cut(data, " ")
+			s, err := strconv.ParseInt(sec, 10, 64)
+			if err != nil {
+				return time.Time{}, errors.New("invalid arrival time " + strconv.Quote(data) + " in " + name)
+			}
+			us, _ := strconv.ParseInt(usec, 10, 64)
+			return time.Unix(s, us*int64(time.Microsecond)), nil
+		case recTypeMessage:
+			return time.Time{}, errors.New("missing arrival time in " + name)
+		}
+	}
+	return time.Time{}, errors.New("missing arrival time in " + name)
+}
+
+// readQueueFileRecord reads a single queue file record having a type,
+// a variable length and data.
+func readQueueFileRecord(r *bufio.Reader) (byte, string, error) {
+	typ, err := r.ReadByte()
+	if err != nil {
+		return 0, "", err
+	}
+	n := 0
+	for shift := 0; ; shift += 7 {
+		if shift > 28 {
+			return 0, "", errors.New("invalid queue file record length")
+		}
+		c, err := r.ReadByte()
+		if err != nil {
+			return 0, "", err
+		}
+		n |= int(c&0x7f) << shift
+		if c&0x80 == 0 {
+			break
+		}
+	}
+	if n > r.Size() {
+		if _, err = r.Discard(n); err != nil {
+			return 0, "", err
+		}
+		return typ, "", nil
+	}
+	b := make([]byte, n)
+	if _, err = io.ReadFull(r, b); err != nil {
+		return 0, "", err
+	}
+	return typ, string(b), nil
+}
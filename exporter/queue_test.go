@@ -0,0 +1,130 @@
+package exporter
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/prometheus/common/promslog"
+)
+
+func writeQueueFile(t *testing.T, name string, arrival time.Time, body string) {
+	t.Helper()
+	record := func(typ byte, data string) []byte {
+		b := []byte{typ}
+		n := len(data)
+		for n >= 0x80 {
+			b = append(b, byte(n)|0x80)
+			n >>= 7
+		}
+		b = append(b, byte(n))
+		return append(b, data...)
+	}
+	var b []byte
+	b = append(b, record('C', "            123             45              1              0")...)
+	if !arrival.IsZero() {
+		b = append(b, record('T', strconv.FormatInt(arrival.Unix(), 10)+" 0")...)
+	}
+	b = append(b, record('M', "")...)
+	b = append(b, record('N', body)...)
+	if err := os.MkdirAll(filepath.Dir(name), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(name, b, 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestQueue_Collect(t *testing.T) {
+	now := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	dir := t.TempDir()
+	for _, name := range []string{"maildrop", "incoming", "active", "hold"} {
+		if err := os.Mkdir(filepath.Join(dir, name), 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeQueueFile(t, filepath.Join(dir, "active", "0123456789A"), now.Add(-time.Minute), "text")
+	writeQueueFile(t, filepath.Join(dir, "deferred", "B", "0123456789B"), now.Add(-time.Hour), strings.Repeat("x", 200))
+	writeQueueFile(t, filepath.Join(dir, "deferred", "C", "0123456789C"), now.Add(-2*time.Hour), "text")
+	// Deferred queue files without the arrival time are only counted.
+	writeQueueFile(t, filepath.Join(dir, "deferred", "D", "0123456789D"), time.Time{}, "text")
+	q, err := NewQueue(dir, time.Hour, promslog.NewNopLogger())
+	if err != nil {
+		t.Fatalf("NewQueue() = _, %v; want nil", err)
+	}
+	defer q.Close()
+	q.now = func() time.Time { return now }
+	const metrics = `# HELP postfix_queue_messages Number of messages in a queue.
+# TYPE postfix_queue_messages gauge
+postfix_queue_messages{queue="active"} 1
+postfix_queue_messages{queue="deferred"} 3
+postfix_queue_messages{queue="hold"} 0
+postfix_queue_messages{queue="incoming"} 0
+postfix_queue_messages{queue="maildrop"} 0
+# HELP postfix_queue_oldest_message_age_seconds Age in seconds of the oldest message in a queue.
+# TYPE postfix_queue_oldest_message_age_seconds gauge
+postfix_queue_oldest_message_age_seconds{queue="active"} 60
+postfix_queue_oldest_message_age_seconds{queue="deferred"} 7200
+postfix_queue_oldest_message_age_seconds{queue="hold"} 0
+postfix_queue_oldest_message_age_seconds{queue="incoming"} 0
+postfix_queue_oldest_message_age_seconds{queue="maildrop"} 0
+# HELP postfix_queue_scan_errors_total Total number of errors scanning the queue directory.
+# TYPE postfix_queue_scan_errors_total counter
+postfix_queue_scan_errors_total 0
+# HELP postfix_queue_size_bytes Total size in bytes of queue files in a queue.
+# TYPE postfix_queue_size_bytes gauge
+postfix_queue_size_bytes{queue="active"} 84
+postfix_queue_size_bytes{queue="deferred"} 435
+postfix_queue_size_bytes{queue="hold"} 0
+postfix_queue_size_bytes{queue="incoming"} 0
+postfix_queue_size_bytes{queue="maildrop"} 0
+`
+	if err := testutil.CollectAndCompare(q, strings.NewReader(metrics)); err != nil {
+		t.Errorf("testutil.CollectAndCompare() = %v; want nil", err)
+	}
+}
+
+func TestQueue_ArrivalCache(t *testing.T) {
+	now := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	dir := t.TempDir()
+	name := filepath.Join(dir, "active", "0123456789A")
+	writeQueueFile(t, name, now.Add(-time.Minute), "text")
+	modified := now.Add(-time.Second)
+	if err := os.Chtimes(name, modified, modified); err != nil {
+		t.Fatal(err)
+	}
+	q, err := NewQueue(dir, time.Hour, promslog.NewNopLogger())
+	if err != nil {
+		t.Fatalf("NewQueue() = _, %v; want nil", err)
+	}
+	defer q.Close()
+	q.now = func() time.Time { return now }
+	want := func(age string) {
+		t.Helper()
+		metrics := `# HELP postfix_queue_oldest_message_age_seconds Age in seconds of the oldest message in a queue.
+# TYPE postfix_queue_oldest_message_age_seconds gauge
+postfix_queue_oldest_message_age_seconds{queue="active"} ` + age + `
+`
+		if err := testutil.CollectAndCompare(q, strings.NewReader(metrics), "postfix_queue_oldest_message_age_seconds"); err != nil {
+			t.Errorf("testutil.CollectAndCompare() = %v; want nil", err)
+		}
+	}
+	want("60")
+	// An unchanged queue file isn't read again.
+	writeQueueFile(t, name, now.Add(-time.Hour), "text")
+	if err := os.Chtimes(name, modified, modified); err != nil {
+		t.Fatal(err)
+	}
+	q.scan()
+	want("60")
+	modified = modified.Add(time.Millisecond)
+	if err := os.Chtimes(name, modified, modified); err != nil {
+		t.Fatal(err)
+	}
+	q.scan()
+	want("3600")
+}
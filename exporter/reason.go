@@ -0,0 +1,67 @@
+package exporter
+
+import (
+	"regexp"
+	"strings"
+)
+
+// delayReasons classify delay reasons of deferred recipients, checked in order.
+var delayReasons = []struct {
+	re    *regexp.Regexp
+	class string
+}{
+	{regexp.MustCompile(`(?i)gr[ae]y-?list`), "greylisting"},
+	{regexp.MustCompile(`(?i)(mailbox|disk) (is )?full|quota|insufficient (system )?storage`), "quota"},
+	{regexp.MustCompile(`(?i)\bTLS\b|SSL|certificate`), "tls"},
+	{regexp.MustCompile(`(?i)said: 4\d\d\b`), "remote_temporary"},
+	{regexp.MustCompile(`(?i)said: 5\d\d\b`), "remote_permanent"},
+	{regexp.MustCompile(`(?i)connection timed out|conversation with .+ timed out`), "timeout"},
+	{regexp.MustCompile(`(?i)connection refused`), "connection_refused"},
+	{regexp.MustCompile(`(?i)network is unreachable|no route to host`), "network_unreachable"},
+	{regexp.MustCompile(`(?i)host (or domain name )?not found|name service error|name or service not known|unable to look up host|no MX host|malformed DNS|DNS lookup`), "dns"},
+	{regexp.MustCompile(`(?i)lost connection`), "lost_connection"},
+	{regexp.MustCompile(`(?i)delivery temporarily suspended`), "suspended"},
+}
+
+// classifyDelayReason returns a delay reason class, such as timeout
+// or greylisting, or "other" for an unknown reason.
+func classifyDelayReason(s string) string {
+	for _, reason := range delayReasons {
+		if reason.re.MatchString(s) {
+			return reason.class
+		}
+	}
+	return otherLabelValue
+}
+
+// reRBL matches DNS blocklist rejections, capturing the list name.
+var reRBL = regexp.MustCompile(`(?i)blocked using ([^\s;,]+)`)
+
+// rejectReasons classify reject reasons of NOQUEUE rejects, checked in order after reRBL.
+var rejectReasons = []struct {
+	re    *regexp.Regexp
+	class string
+}{
+	{regexp.MustCompile(`(?i)\bSPF\b`), "spf"},
+	{regexp.MustCompile(`(?i)gr[ae]y-?list`), "greylisting"},
+	{regexp.MustCompile(`(?i)rate.?limit|too (many|much) (mail|messages|connections|recipients)|limit exceeded`), "rate_limit"},
+	{regexp.MustCompile(`(?i)Client host rejected: cannot find your (reverse )?hostname`), "unknown_client_hostname"},
+	{regexp.MustCompile(`(?i)Sender address rejected: Domain not found`), "unknown_sender_domain"},
+	{regexp.MustCompile(`(?i)Relay access denied`), "relay_access_denied"},
+	{regexp.MustCompile(`(?i)User unknown|Recipient address rejected: (undeliverable|unverified) address`), "unknown_recipient"},
+	{regexp.MustCompile(`(?i)\bpolicy\b|policyd`), "policy_service"},
+}
+
+// classifyRejectReason returns a reject reason class, such as spf
+// or rbl:<list name>, or "other" for an unknown reason.
+func classifyRejectReason(s string) string {
+	if matches := reRBL.FindStringSubmatch(s); matches != nil {
+		return "rbl:" + strings.ToLower(matches[1])
+	}
+	for _, reason := range rejectReasons {
+		if reason.re.MatchString(s) {
+			return reason.class
+		}
+	}
+	return otherLabelValue
+}
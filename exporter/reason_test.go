@@ -0,0 +1,51 @@
+package exporter
+
+import "testing"
+
+func TestClassifyDelayReason(t *testing.T) {
+	tests := []struct {
+		reason string
+		want   string
+	}{
+		{"connect to mx.example.org[192.0.2.1]:25: Connection timed out", "timeout"},
+		{"connect to mx.example.org[192.0.2.1]:25: Connection refused", "connection_refused"},
+		{"connect to mx.example.org[2001:db8::1]:25: Network is unreachable", "network_unreachable"},
+		{"Host or domain name not found. Name service error for name=example.org type=MX: Host not found, try again", "dns"},
+		{"host mx.example.org[192.0.2.1] said: 450 4.7.1 Greylisted, please try again later (in reply to RCPT TO command)", "greylisting"},
+		{"host mx.example.org[192.0.2.1] said: 452 4.2.2 Mailbox full (in reply to RCPT TO command)", "quota"},
+		{"host mx.example.org[192.0.2.1] said: 421 4.7.0 Try again later (in reply to end of DATA command)", "remote_temporary"},
+		{"host mx.example.org[192.0.2.1] said: 550 5.1.1 User unknown (in reply to RCPT TO command)", "remote_permanent"},
+		{"Cannot start TLS: handshake failure", "tls"},
+		{"lost connection with mx.example.org[192.0.2.1] while receiving the initial server greeting", "lost_connection"},
+		{"delivery temporarily suspended: unknown mail transport error", "suspended"},
+		{"unknown mail transport error", "other"},
+	}
+	for _, test := range tests {
+		if got := classifyDelayReason(test.reason); got != test.want {
+			t.Errorf("classifyDelayReason(%q) = %q; want %q", test.reason, got, test.want)
+		}
+	}
+}
+
+func TestClassifyRejectReason(t *testing.T) {
+	tests := []struct {
+		reason string
+		want   string
+	}{
+		{"Service unavailable; Client host [192.0.2.1] blocked using zen.spamhaus.org", "rbl:zen.spamhaus.org"},
+		{"Recipient address rejected: Rejected by SPF: 192.0.2.1 is not a designated mailserver for user%40example.org", "spf"},
+		{"Client host rejected: cannot find your reverse hostname, [192.0.2.1]", "unknown_client_hostname"},
+		{"Sender address rejected: Domain not found", "unknown_sender_domain"},
+		{"Relay access denied", "relay_access_denied"},
+		{"Recipient address rejected: User unknown in virtual mailbox table", "unknown_recipient"},
+		{"Recipient address rejected: Greylisted, see http://postgrey.schweikert.ch/help/example.org.html", "greylisting"},
+		{"Sender address rejected: Rate limit exceeded", "rate_limit"},
+		{"Recipient address rejected: Message rejected by policy", "policy_service"},
+		{"Helo command rejected: need fully-qualified hostname", "other"},
+	}
+	for _, test := range tests {
+		if got := classifyRejectReason(test.reason); got != test.want {
+			t.Errorf("classifyRejectReason(%q) = %q; want %q", test.reason, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,326 @@
+package exporter
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const maxSyslogMessageSize = 64 << 10
+
+// Syslog collects Postfix logs from syslog messages received over the network.
+type Syslog struct {
+	UDPAddress string
+	TCPAddress string
+	UnixPath   string
+
+	packetConns []net.PacketConn
+	listeners   []net.Listener
+	mu          sync.Mutex
+	conns       map[net.Conn]struct{}
+	done        chan struct{}
+	wg          sync.WaitGroup
+}
+
+func (s *Syslog) Collect(ch chan<- result) error {
+	if s.UDPAddress == "" && s.TCPAddress == "" && s.UnixPath == "" {
+		return errors.New("no syslog listen address")
+	}
+	s.done = make(chan struct{})
+	s.conns = make(map[net.Conn]struct{})
+	if s.UDPAddress != "" {
+		c, err := net.ListenPacket("udp", s.UDPAddress)
+		if err != nil {
+			s.Close()
+			return err
+		}
+		s.servePacket(c, ch)
+	}
+	if s.UnixPath != "" {
+		// Remove a stale socket left after an unclean shutdown.
+		if fi, err := os.Lstat(s.UnixPath); err == nil && fi.Mode()&os.ModeSocket != 0 {
+			os.Remove(s.UnixPath)
+		}
+		c, err := net.ListenPacket("unixgram", s.UnixPath)
+		if err != nil {
+			s.Close()
+			return err
+		}
+		s.servePacket(c, ch)
+	}
+	if s.TCPAddress != "" {
+		l, err := net.Listen("tcp", s.TCPAddress)
+		if err != nil {
+			s.Close()
+			return err
+		}
+		s.serveStream(l, ch)
+	}
+	return nil
+}
+
+func (s *Syslog) servePacket(c net.PacketConn, ch chan<- result) {
+	s.packetConns = append(s.packetConns, c)
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		buf := make([]byte, maxSyslogMessageSize)
+		for {
+			n, _, err := c.ReadFrom(buf)
+			if err != nil {
+				if errors.Is(err, net.ErrClosed) {
+					return
+				}
+				continue
+			}
+			if !s.send(ch, buf[:n]) {
+				return
+			}
+		}
+	}()
+}
+
+func (s *Syslog) serveStream(l net.Listener, ch chan<- result) {
+	s.listeners = append(s.listeners, l)
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				if errors.Is(err, net.ErrClosed) {
+					return
+				}
+				continue
+			}
+			s.mu.Lock()
+			s.conns[c] = struct{}{}
+			s.mu.Unlock()
+			s.wg.Add(1)
+			go func() {
+				defer s.wg.Done()
+				defer func() {
+					s.mu.Lock()
+					delete(s.conns, c)
+					s.mu.Unlock()
+					c.Close()
+				}()
+				r := bufio.NewReader(c)
+				for {
+					b, err := readSyslogFrame(r)
+					if len(b) > 0 && !s.send(ch, b) {
+						return
+					}
+					if err != nil {
+						return
+					}
+				}
+			}()
+		}
+	}()
+}
+
+func (s *Syslog) send(ch chan<- result, b []byte) bool {
+	var res result
+	line, err := formatSyslog(b)
+	if err == nil {
+		res.rec, res.err = parseRecord(line)
+	} else {
+		res.err = err
+	}
+	select {
+	case ch <- res:
+		return true
+	case <-s.done:
+		return false
+	}
+}
+
+func (s *Syslog) Wait() {
+	s.wg.Wait()
+}
+
+func (s *Syslog) Close() error {
+	if s.done != nil {
+		select {
+		case <-s.done:
+		default:
+			close(s.done)
+		}
+	}
+	var errs []error
+	for _, c := range s.packetConns {
+		errs = append(errs, c.Close())
+	}
+	for _, l := range s.listeners {
+		errs = append(errs, l.Close())
+	}
+	s.mu.Lock()
+	for c := range s.conns {
+		c.Close()
+	}
+	s.mu.Unlock()
+	if s.UnixPath != "" && len(s.packetConns) > 0 {
+		os.Remove(s.UnixPath)
+	}
+	return errors.Join(errs...)
+}
+
+// readSyslogFrame reads a single message from a stream using either
+// octet counting or non-transparent framing (RFC 6587).
+func readSyslogFrame(r *bufio.Reader) ([]byte, error) {
+	for {
+		c, err := r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		if c >= '1' && c <= '9' {
+			n := int(c - '0')
+			for {
+				c, err = r.ReadByte()
+				if err != nil {
+					return nil, err
+				}
+				if c == ' ' {
+					break
+				}
+				if c < '0' || c > '9' {
+					return nil, errors.New("invalid syslog frame length character " + strconv.QuoteRune(rune(c)))
+				}
+				n = n*10 + int(c-'0')
+				if n > maxSyslogMessageSize {
+					return nil, errors.New("syslog frame too large")
+				}
+			}
+			b := make([]byte, n)
+			_, err = io.ReadFull(r, b)
+			return b, err
+		}
+		if c == '\n' || c == '\r' || c == 0 {
+			// Skip empty frames.
+			continue
+		}
+		if err = r.UnreadByte(); err != nil {
+			return nil, err
+		}
+		// Read the frame in chunks not to buffer an endless line.
+		var b []byte
+		for {
+			chunk, err := r.ReadSlice('\n')
+			if len(b)+len(chunk) > maxSyslogMessageSize {
+				return nil, errors.New("syslog frame too large")
+			}
+			b = append(b, chunk...)
+			if err != bufio.ErrBufferFull {
+				return b, err
+			}
+		}
+	}
+}
+
+// formatSyslog converts an RFC 3164 or RFC 5424 message into a log line.
+func formatSyslog(b []byte) (string, error) {
+	b = bytes.TrimRight(b, "\r\n\x00")
+	s := string(b)
+	if !strings.HasPrefix(s, "<") {
+		return "", errors.New("missing priority in " + strconv.Quote(s))
+	}
+	i := strings.IndexByte(s, '>')
+	if i < 2 || i > 4 {
+		return "", errors.New("invalid priority in " + strconv.Quote(s))
+	}
+	if pri, err := strconv.Atoi(s[1:i]); err != nil || pri > 191 {
+		return "", errors.New("invalid priority in " + strconv.Quote(s))
+	}
+	s = s[i+1:]
+	if !strings.HasPrefix(s, "1 ") {
+		// RFC 3164 messages only differ from log lines by priority,
+		// but local ones, like from /dev/log, may have no hostname.
+		return addSyslogHostname(s), nil
+	}
+	s = s[2:]
+	var fields [5]string
+	for i := range fields {
+		j := strings.IndexByte(s, ' ')
+		if j == -1 {
+			return "", errors.New("missing RFC 5424 header field in " + strconv.Quote(string(b)))
+		}
+		fields[i], s = s[:j], s[j+1:]
+	}
+	ts, hostname, app, procID := fields[0], fields[1], fields[2], fields[3]
+	if ts == "-" {
+		ts = time.Now().Format(time.RFC3339Nano)
+	}
+	if procID == "-" {
+		procID = "0"
+	}
+	s, err := skipStructuredData(s)
+	if err != nil {
+		return "", errors.New(err.Error() + " in " + strconv.Quote(string(b)))
+	}
+	s = strings.TrimPrefix(strings.TrimPrefix(s, " "), "\ufeff")
+	return ts + " " + hostname + " " + app + "[" + procID + "]: " + s, nil
+}
+
+// addSyslogHostname adds the localhost hostname to an RFC 3164 message
+// having the tag, like "postfix/smtpd[12345]:", right after the timestamp.
+func addSyslogHostname(s string) string {
+	// The timestamp is either RFC 3339 or a classic BSD one of 3 fields.
+	n := 3
+	if ts, _, _ := strings.Cut(s, " "); strings.Contains(ts, ":") {
+		n = 1
+	}
+	i := 0
+	for range n {
+		for i < len(s) && s[i] == ' ' {
+			i++
+		}
+		j := strings.IndexByte(s[i:], ' ')
+		if j == -1 {
+			return s
+		}
+		i += j
+	}
+	tag, _, _ := strings.Cut(strings.TrimLeft(s[i:], " "), " ")
+	if !strings.HasSuffix(tag, ":") {
+		return s
+	}
+	return s[:i] + " localhost" + s[i:]
+}
+
+func skipStructuredData(s string) (string, error) {
+	if strings.HasPrefix(s, "-") {
+		return s[1:], nil
+	}
+	for strings.HasPrefix(s, "[") {
+		quoted := false
+		i := 1
+	loop:
+		for ; i < len(s); i++ {
+			switch s[i] {
+			case '\\':
+				if quoted {
+					i++
+				}
+			case '"':
+				quoted = !quoted
+			case ']':
+				if !quoted {
+					break loop
+				}
+			}
+		}
+		if i >= len(s) {
+			return "", errors.New("unterminated structured data")
+		}
+		s = s[i+1:]
+	}
+	return s, nil
+}
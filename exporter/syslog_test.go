@@ -0,0 +1,234 @@
+package exporter
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/prometheus/common/promslog"
+	"github.com/sergeymakinen/postfix_exporter/v2/config"
+)
+
+// sentinelProgram is the program of the last record sent to a syslog collector.
+const sentinelProgram = "sentinel"
+
+// syslogSentinel is a syslog collector waiting for the sentinel record
+// instead of being closed.
+type syslogSentinel struct {
+	*Syslog
+	seen chan struct{}
+	done chan struct{}
+}
+
+func (s *syslogSentinel) Collect(ch chan<- result) error {
+	s.seen = make(chan struct{})
+	s.done = make(chan struct{})
+	in := make(chan result)
+	go func() {
+		for {
+			select {
+			case res := <-in:
+				select {
+				case ch <- res:
+				case <-s.done:
+					return
+				}
+				if res.rec.Program == sentinelProgram {
+					close(s.seen)
+				}
+			case <-s.done:
+				return
+			}
+		}
+	}()
+	return s.Syslog.Collect(in)
+}
+
+func (s *syslogSentinel) Wait() {
+	select {
+	case <-s.seen:
+	case <-time.After(10 * time.Second):
+	}
+}
+
+func (s *syslogSentinel) Close() error {
+	close(s.done)
+	return s.Syslog.Close()
+}
+
+func TestExporter_Syslog_Collect(t *testing.T) {
+	formatRFC3164 := func(s string, r record) string {
+		return "<22>" + s + "\n"
+	}
+	formatRFC5424 := func(s string, r record) string {
+		id := r.Program
+		if r.Subprogram != "" {
+			id += "/" + r.Subprogram
+		}
+		var severity string
+		if r.Severity != severityInfo {
+			severity = string(r.Severity) + ": "
+		}
+		msg := "<22>1 " + r.Time.Format(time.RFC3339Nano) + " " + r.Hostname + " " + id + " " + strconv.FormatInt(r.PID, 10) + ` - [meta x="a\]b"] ` + "\ufeff" + severity + r.Text
+		// Octet counting.
+		return strconv.Itoa(len(msg)) + " " + msg
+	}
+	transports := map[string]struct {
+		Network string
+		Format  func(s string, r record) string
+	}{
+		"udp":      {Network: "udp", Format: formatRFC3164},
+		"unixgram": {Network: "unixgram", Format: formatRFC3164},
+		"tcp":      {Network: "tcp", Format: formatRFC5424},
+	}
+	for network, transport := range transports {
+		for name, test := range tests {
+			t.Run(network+" "+name, func(t *testing.T) {
+				var (
+					cfg *config.Config
+					err error
+				)
+				if test.Cfg != "" {
+					cfg, err = config.Load(test.Cfg)
+					if err != nil {
+						t.Fatal(err)
+					}
+				}
+				collector := &syslogSentinel{Syslog: &Syslog{}}
+				switch transport.Network {
+				case "udp":
+					collector.UDPAddress = "127.0.0.1:0"
+				case "unixgram":
+					collector.UnixPath = filepath.Join(t.TempDir(), "syslog.sock")
+				case "tcp":
+					collector.TCPAddress = "127.0.0.1:0"
+				}
+				exporter, err := New(collector, testInstance, cfg, promslog.NewNopLogger())
+				if err != nil {
+					t.Fatalf("New() = _, %v; want nil", err)
+				}
+				defer exporter.Close()
+				var addr string
+				if len(collector.listeners) > 0 {
+					addr = collector.listeners[0].Addr().String()
+				} else {
+					addr = collector.packetConns[0].LocalAddr().String()
+				}
+				conn, err := net.Dial(transport.Network, addr)
+				if err != nil {
+					t.Fatal(err)
+				}
+				defer conn.Close()
+				in, err := os.Open("testdata/mail.log")
+				if err != nil {
+					t.Fatal(err)
+				}
+				defer in.Close()
+				buf := bufio.NewReader(in)
+				for {
+					s, err := buf.ReadString('\n')
+					if err == io.EOF {
+						break
+					}
+					if err != nil {
+						t.Fatal(err)
+					}
+					s = strings.TrimSuffix(s, "\n")
+					r, err := parseRecord(s)
+					if err != nil {
+						continue
+					}
+					if _, err = conn.Write([]byte(transport.Format(s, r))); err != nil {
+						t.Fatal(err)
+					}
+				}
+				const sentinel = "Jan 1 00:00:00 hostname " + sentinelProgram + "[1]: done"
+				r, _ := parseRecord(sentinel)
+				if _, err = conn.Write([]byte(transport.Format(sentinel, r))); err != nil {
+					t.Fatal(err)
+				}
+				exporter.Wait()
+				b, err := os.ReadFile(test.Metrics)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if err := testutil.CollectAndCompare(exporter, bytes.NewReader(b), testMetrics...); err != nil {
+					t.Errorf("testutil.CollectAndCompare() = %v; want nil", err)
+				}
+			})
+		}
+	}
+}
+
+func TestReadSyslogFrame(t *testing.T) {
+	r := bufio.NewReader(strings.NewReader("5 <22>a\n<22>b\n\n" + strings.Repeat("x", maxSyslogMessageSize+1)))
+	for _, want := range []string{"<22>a", "<22>b\n"} {
+		b, err := readSyslogFrame(r)
+		if err != nil || string(b) != want {
+			t.Errorf("readSyslogFrame() = %q, %v; want %q, nil", b, err, want)
+		}
+	}
+	if _, err := readSyslogFrame(r); err == nil {
+		t.Error("readSyslogFrame() = _, nil; want error")
+	}
+	r = bufio.NewReader(strings.NewReader(strings.Repeat("9", 100)))
+	if _, err := readSyslogFrame(r); err == nil {
+		t.Error("readSyslogFrame() = _, nil; want error")
+	}
+}
+
+func TestFormatSyslog(t *testing.T) {
+	tests := []struct {
+		Msg  string
+		Line string
+	}{
+		{
+			Msg:  "<22>Jan  1 00:00:00 hostname postfix/smtpd[12345]: connect from example.com[123.45.67.89]",
+			Line: "Jan  1 00:00:00 hostname postfix/smtpd[12345]: connect from example.com[123.45.67.89]",
+		},
+		{
+			Msg:  "<22>Jan  1 00:00:00 postfix/smtpd[12345]: connect from example.com[123.45.67.89]",
+			Line: "Jan  1 00:00:00 localhost postfix/smtpd[12345]: connect from example.com[123.45.67.89]",
+		},
+		{
+			Msg:  "<22>2023-02-01T01:02:04Z postfix/smtpd[12345]: connect from example.com[123.45.67.89]",
+			Line: "2023-02-01T01:02:04Z localhost postfix/smtpd[12345]: connect from example.com[123.45.67.89]",
+		},
+		{
+			Msg:  "<22>1 2023-02-01T01:02:04.123456+00:00 hostname postfix/smtpd 12345 - - connect from example.com[123.45.67.89]\n",
+			Line: "2023-02-01T01:02:04.123456+00:00 hostname postfix/smtpd[12345]: connect from example.com[123.45.67.89]",
+		},
+		{
+			Msg:  `<22>1 2023-02-01T01:02:04Z hostname postfix/smtp - ID1 [a b="c\"]"][d] ` + "\ufeffwarning: text",
+			Line: "2023-02-01T01:02:04Z hostname postfix/smtp[0]: warning: text",
+		},
+	}
+	for _, test := range tests {
+		line, err := formatSyslog([]byte(test.Msg))
+		if err != nil {
+			t.Errorf("formatSyslog(%q) = _, %v; want nil", test.Msg, err)
+			continue
+		}
+		if line != test.Line {
+			t.Errorf("formatSyslog(%q) = %q; want %q", test.Msg, line, test.Line)
+		}
+	}
+	for _, msg := range []string{
+		"Jan  1 00:00:00 hostname postfix/smtpd[12345]: text",
+		"<1234>Jan  1 00:00:00 hostname postfix/smtpd[12345]: text",
+		"<22>1 2023-02-01T01:02:04Z hostname",
+		"<22>1 2023-02-01T01:02:04Z hostname postfix/smtp 12345 - [a b=\"]",
+	} {
+		if _, err := formatSyslog([]byte(msg)); err == nil {
+			t.Errorf("formatSyslog(%q) = _, nil; want error", msg)
+		}
+	}
+}
@@ -0,0 +1,13 @@
+#!/bin/sh
+# Prints the postconf -x output fixture.
+cat <<'END'
+compatibility_level = 3.6
+END
+echo "config_directory = $POSTCONF_TEST_DIR"
+echo "process_id = $$"
+cat <<'END'
+inet_protocols = ipv4, ipv6
+mail_version = 3.7.11
+message_size_limit = 52428800
+smtpd_tls_security_level = may
+END
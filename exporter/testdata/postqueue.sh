@@ -0,0 +1,3 @@
+#!/bin/sh
+# Prints the postqueue -j output fixture.
+exec cat "$(dirname "$0")/postqueue.json"
@@ -0,0 +1,126 @@
+package exporter
+
+import (
+	"container/list"
+	"time"
+)
+
+// Message outcomes ordered by precedence: a message with several
+// recipients has the outcome of the highest one.
+var outcomes = map[string]int{
+	"deferred": 1,
+	"sent":     2,
+	"bounced":  3,
+	"expired":  4,
+}
+
+// messageKey identifies a message by the Postfix instance and queue ID.
+type messageKey struct {
+	instance string
+	id       string
+}
+
+// message is a tracked message. origin is the subprogram the message came from:
+// smtpd, pickup or bounce, subprogram is the full smtpd subprogram name, such as
+// submission/smtpd, and notification is the bounce notification type
+// if the message is a notification.
+type message struct {
+	key          messageKey
+	accepted     bool
+	active       bool
+	start        time.Time
+	size         float64
+	nrcpt        float64
+	outcome      string
+	origin       string
+	subprogram   string
+	notification string
+	expiresAt    time.Time
+	elem         *list.Element
+}
+
+func (m *message) deliver(status string) {
+	if outcomes[status] >= outcomes[m.outcome] {
+		m.outcome = status
+	}
+}
+
+// clockJump is how far back the log record time must go to be treated as a clock jump,
+// like the year rollover of timestamps without a year, rather than out of order records.
+const clockJump = 24 * time.Hour
+
+// tracker follows messages by queue ID keeping at most maxMessages
+// for no longer than ttl of the log record time.
+type tracker struct {
+	maxMessages int
+	ttl         time.Duration
+	messages    map[messageKey]*message
+	order       *list.List
+	now         time.Time
+	evict       func()
+}
+
+func newTracker(maxMessages int, ttl time.Duration, evict func()) *tracker {
+	return &tracker{
+		maxMessages: maxMessages,
+		ttl:         ttl,
+		messages:    make(map[messageKey]*message),
+		order:       list.New(),
+		evict:       evict,
+	}
+}
+
+// get returns a tracked message, adding a new one if create is true.
+func (t *tracker) get(instance, id string, create bool) *message {
+	t.expire()
+	key := messageKey{instance: instance, id: id}
+	if m, ok := t.messages[key]; ok {
+		return m
+	}
+	if !create {
+		return nil
+	}
+	for len(t.messages) >= t.maxMessages && t.order.Len() > 0 {
+		t.remove(t.order.Front().Value.(*message))
+		t.evict()
+	}
+	m := &message{
+		key:       key,
+		expiresAt: t.now.Add(t.ttl),
+	}
+	m.elem = t.order.PushBack(m)
+	t.messages[key] = m
+	return m
+}
+
+func (t *tracker) remove(m *message) {
+	t.order.Remove(m.elem)
+	delete(t.messages, m.key)
+}
+
+// advance moves the tracker clock to the log record time now. The clock doesn't go back
+// for out of order records, and on a clock jump the expiration times are shifted.
+func (t *tracker) advance(now time.Time) {
+	switch {
+	case t.now.IsZero() || now.After(t.now):
+		t.now = now
+	case t.now.Sub(now) > clockJump:
+		d := now.Sub(t.now)
+		for elem := t.order.Front(); elem != nil; elem = elem.Next() {
+			m := elem.Value.(*message)
+			m.expiresAt = m.expiresAt.Add(d)
+		}
+		t.now = now
+	}
+}
+
+func (t *tracker) expire() {
+	for t.order.Len() > 0 {
+		m := t.order.Front().Value.(*message)
+		if t.now.Before(m.expiresAt) {
+			break
+		}
+		t.remove(m)
+		t.evict()
+	}
+}
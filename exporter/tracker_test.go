@@ -0,0 +1,74 @@
+package exporter
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTracker_Evict(t *testing.T) {
+	var evictions int
+	now := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	tr := newTracker(2, time.Hour, func() { evictions++ })
+	tr.advance(now)
+	tr.get("postfix", "A", true)
+	tr.get("postfix", "B", true)
+	tr.get("postfix", "C", true)
+	if m := tr.get("postfix-out", "B", false); m != nil {
+		t.Errorf("get(%q, %q, false) = %+v; want nil", "postfix-out", "B", m)
+	}
+	if m := tr.get("postfix", "A", false); m != nil {
+		t.Errorf("get(%q, %q, false) = %+v; want nil", "postfix", "A", m)
+	}
+	if evictions != 1 {
+		t.Errorf("evictions = %d; want 1", evictions)
+	}
+	tr.advance(now.Add(time.Hour))
+	if m := tr.get("postfix", "C", false); m != nil {
+		t.Errorf("get(%q, %q, false) = %+v; want nil", "postfix", "C", m)
+	}
+	if evictions != 3 {
+		t.Errorf("evictions = %d; want 3", evictions)
+	}
+}
+
+func TestMessage_Deliver(t *testing.T) {
+	tests := []struct {
+		Statuses []string
+		Outcome  string
+	}{
+		{Statuses: []string{"deferred", "sent"}, Outcome: "sent"},
+		{Statuses: []string{"sent", "deferred"}, Outcome: "sent"},
+		{Statuses: []string{"bounced", "sent"}, Outcome: "bounced"},
+		{Statuses: []string{"deferred", "expired"}, Outcome: "expired"},
+	}
+	for _, test := range tests {
+		var m message
+		for _, status := range test.Statuses {
+			m.deliver(status)
+		}
+		if m.outcome != test.Outcome {
+			t.Errorf("deliver(%q) outcome = %q; want %q", test.Statuses, m.outcome, test.Outcome)
+		}
+	}
+}
+
+func TestTracker_Advance(t *testing.T) {
+	now := time.Date(0, 12, 31, 23, 0, 0, 0, time.UTC)
+	tr := newTracker(10, time.Hour, func() {})
+	tr.advance(now)
+	tr.get("postfix", "A", true)
+	// Out of order records don't move the clock back.
+	tr.advance(now.Add(-time.Minute))
+	if !tr.now.Equal(now) {
+		t.Errorf("now = %v; want %v", tr.now, now)
+	}
+	// The year rollover of timestamps without a year keeps the time to live remaining before the jump.
+	tr.advance(time.Date(0, 1, 1, 0, 30, 0, 0, time.UTC))
+	if m := tr.get("postfix", "A", false); m == nil {
+		t.Errorf("get(%q, %q, false) = nil; want message", "postfix", "A")
+	}
+	tr.advance(time.Date(0, 1, 1, 1, 30, 0, 0, time.UTC))
+	if m := tr.get("postfix", "A", false); m != nil {
+		t.Errorf("get(%q, %q, false) = %+v; want nil", "postfix", "A", m)
+	}
+}